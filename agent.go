@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	flag "github.com/juju/gnuflag"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/httpbakery/agent"
+)
+
+const agentHelpMessage = `usage: http agent [flag...] create|add|list|remove [arg...]
+
+Manage the agent keys used for agent authentication.
+
+  create
+      Create a new agents file holding a newly generated key pair
+      and print its public key. The key can then be registered
+      with an identity manager.
+
+  add URL USERNAME
+      Use the agent key to log in as USERNAME when discharging
+      macaroons for URL.
+
+  list
+      Print the public key and the agents in the agents file.
+
+  remove URL
+      Remove the agent for URL.
+
+The agents file must not be accessible by other users.
+
+`
+
+// agentCmd implements the agent subcommand.
+func agentCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+	var agentFile string
+	fset.StringVar(&agentFile, "agent", defaultAgentFile(), "agents file to use")
+	fset.Usage = func() {
+		fmt.Fprint(stderr, agentHelpMessage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(true, args); err != nil {
+		return &exitError{2}
+	}
+	args = fset.Args()
+	if len(args) == 0 {
+		fset.Usage()
+		return &exitError{2}
+	}
+	cmd, args := args[0], args[1:]
+	if cmd == "create" {
+		if len(args) > 0 {
+			return fmt.Errorf("too many arguments to create")
+		}
+		if _, err := os.Lstat(agentFile); err == nil {
+			return fmt.Errorf("agents file %s already exists", agentFile)
+		}
+		key, err := bakery.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("cannot generate key: %v", err)
+		}
+		if err := writeAgentsFile(agentFile, &agent.AuthInfo{Key: key}); err != nil {
+			return fmt.Errorf("cannot create agents file: %v", err)
+		}
+		fmt.Fprintf(stdout, "%s\n", key.Public)
+		return nil
+	}
+	info, err := readAgentsFile(agentFile)
+	if err != nil {
+		return fmt.Errorf("cannot read agents file: %v", err)
+	}
+	if err := checkAgentsFilePerms(agentFile); err != nil {
+		fwarningf(stderr, "%v", err)
+	}
+	switch cmd {
+	case "list":
+		if len(args) > 0 {
+			return fmt.Errorf("too many arguments to list")
+		}
+		if info.Key != nil {
+			fmt.Fprintf(stdout, "public key: %s\n", info.Key.Public)
+		}
+		w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "URL\tUSERNAME\n")
+		for _, a := range info.Agents {
+			fmt.Fprintf(w, "%s\t%s\n", a.URL, a.Username)
+		}
+		return w.Flush()
+	case "add":
+		if len(args) != 2 {
+			return fmt.Errorf("add requires a URL and a username")
+		}
+		u, err := url.Parse(args[0])
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid agent URL %q", args[0])
+		}
+		info.Agents = removeAgent(info.Agents, args[0])
+		info.Agents = append(info.Agents, agent.Agent{
+			URL:      args[0],
+			Username: args[1],
+		})
+	case "remove":
+		if len(args) != 1 {
+			return fmt.Errorf("remove requires a URL")
+		}
+		agents := removeAgent(info.Agents, args[0])
+		if len(agents) == len(info.Agents) {
+			return fmt.Errorf("no agent found for %q", args[0])
+		}
+		info.Agents = agents
+	default:
+		return fmt.Errorf("unknown agent command %q", cmd)
+	}
+	if err := writeAgentsFile(agentFile, info); err != nil {
+		return fmt.Errorf("cannot write agents file: %v", err)
+	}
+	return nil
+}
+
+// removeAgent returns agents without any entry for the given URL.
+func removeAgent(agents []agent.Agent, url string) []agent.Agent {
+	var result []agent.Agent
+	for _, a := range agents {
+		if a.URL != url {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// writeAgentsFile atomically writes info to the agents file at path,
+// making sure that it's only readable by the current user.
+func writeAgentsFile(path string, info *agent.AuthInfo) error {
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(path, append(data, '\n'))
+}
+
+// checkAgentsFilePerms returns an error if the agents file
+// at path, which holds a private key, is accessible by users
+// other than its owner.
+func checkAgentsFilePerms(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s is accessible by other users (mode %#o); use chmod 600 to fix it", path, perm)
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+)
+
+// authProvider is implemented by each kind of authentication
+// that can be selected with --auth-type. Macaroon and agent
+// authentication are handled separately by the httpbakery client.
+type authProvider interface {
+	// prepare is called before each attempt to send req and may
+	// add credentials to it. If the provider needs to make requests
+	// of its own, it should use client. The client is nil when the
+	// request will not be sent (see --offline), in which case
+	// the provider should add whatever it can without it.
+	// If prepare reads the request body, it must rewind it.
+	prepare(ctx context.Context, client *http.Client, req *http.Request) error
+
+	// challenge is called when the server responds to req
+	// with 401 Unauthorized. It reports whether the
+	// request should be prepared and sent again.
+	challenge(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response) (bool, error)
+
+	// save is called with the final response so that the provider
+	// can persist any state it holds, such as cached tokens.
+	save(resp *http.Response) error
+}
+
+// authProviders holds the built-in authentication providers,
+// keyed by the name used with --auth-type. Each function returns
+// the provider specified by p, or nil if no authentication is
+// needed. Names not found here are taken to be the names of
+// plugins (see newPluginAuth).
+var authProviders = map[string]func(p *params) (authProvider, error){
+	"basic":     newBasicAuth,
+	"digest":    newDigestAuth,
+	"bearer":    newBearerAuth,
+	"aws-sigv4": newSigv4Signer,
+	"oauth2":    newOAuth2Auth,
+}
+
+// authTypeNames returns the names of the built-in authentication
+// providers in a form suitable for messages.
+func authTypeNames() string {
+	names := make([]string, 0, len(authProviders))
+	for name := range authProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// setAuth sets up authentication for the request as
+// specified by the --auth and --auth-type flags.
+func (req *request) setAuth(p *params) error {
+	newProvider := authProviders[p.authType]
+	if newProvider == nil {
+		newProvider = newPluginAuth
+	}
+	a, err := newProvider(p)
+	if err != nil {
+		return err
+	}
+	req.auth = a
+	return nil
+}
+
+// doWithAuth sends the given request, using a to authenticate it.
+// If the server responds with 401 Unauthorized and the provider
+// can answer the challenge, the request is sent again.
+func doWithAuth(ctx context.Context, client *httpbakery.Client, a authProvider, httpReq *http.Request) (*http.Response, error) {
+	body, _ := httpReq.Body.(readSeekCloser)
+	if body != nil {
+		// Stop the client from closing the body
+		// so that it can be sent again.
+		defer body.Close()
+		httpReq.Body = keepOpenBody{body}
+	}
+	for attempt := 0; ; attempt++ {
+		// The client adds cookies to the request's header,
+		// so use a copy for each attempt.
+		req1 := httpReq.WithContext(ctx)
+		req1.Header = httpReq.Header.Clone()
+		if err := a.prepare(ctx, client.Client, req1); err != nil {
+			return nil, err
+		}
+		resp, err := client.DoWithContext(ctx, req1)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, err
+		}
+		retry, err := a.challenge(ctx, client.Client, req1, resp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if !retry {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if err := rewind(body); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rewind seeks back to the start of the given
+// request body, which may be nil.
+func rewind(body io.Seeker) error {
+	if body == nil {
+		return nil
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot rewind request body: %v", err)
+	}
+	return nil
+}
+
+// keepOpenBody wraps a request body so that it is not
+// closed when the request has been sent.
+type keepOpenBody struct {
+	readSeekCloser
+}
+
+func (keepOpenBody) Close() error {
+	return nil
+}
+
+// headerAuth implements authProvider by setting
+// the Authorization header to a fixed value.
+type headerAuth string
+
+func (a headerAuth) prepare(ctx context.Context, client *http.Client, req *http.Request) error {
+	req.Header.Set("Authorization", string(a))
+	return nil
+}
+
+func (headerAuth) challenge(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+func (headerAuth) save(resp *http.Response) error {
+	return nil
+}
+
+// newBasicAuth returns a provider that uses HTTP basic authentication.
+func newBasicAuth(p *params) (authProvider, error) {
+	username, password, err := userPassword(p)
+	if err != nil || username == "" {
+		return nil, err
+	}
+	return headerAuth("Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))), nil
+}
+
+// newBearerAuth returns a provider that sends the
+// token given with --auth as a bearer token.
+func newBearerAuth(p *params) (authProvider, error) {
+	if p.auth == "" {
+		return nil, nil
+	}
+	return headerAuth("Bearer " + p.auth), nil
+}
+
+// userPassword returns the user name and password specified by
+// the --auth flag. If no credentials are given, they're looked up
+// in the netrc file, unless the request isn't being sent. If a user
+// name is given without a password, the password is prompted for.
+// It returns an empty user name if no credentials are found.
+func userPassword(p *params) (username, password string, err error) {
+	auth := p.auth
+	if auth == "" {
+		if p.offline {
+			return "", "", nil
+		}
+		login, password := netrcCredentials(netrcFile(), p.url)
+		return login, password, nil
+	}
+	if i := strings.Index(auth, ":"); i >= 0 {
+		return auth[:i], auth[i+1:], nil
+	}
+	password, err = promptPassword(fmt.Sprintf("http: password for %s@%s: ", auth, p.url.Host))
+	if err != nil {
+		return "", "", fmt.Errorf("cannot read password: %v", err)
+	}
+	return auth, password, nil
+}
+
+// promptPassword prints the given prompt to the terminal and
+// reads a password from it without echoing it. The terminal is
+// opened directly so that standard input and output remain
+// free for the request and response bodies.
+// It's a variable so that it can be replaced in tests.
+var promptPassword = func(prompt string) (string, error) {
+	tty, err := openTerminal()
+	if err != nil {
+		return "", fmt.Errorf("no password given and cannot open terminal: %v", err)
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("no password given and no terminal to prompt on")
+	}
+	var out io.Writer = tty
+	if runtime.GOOS == "windows" {
+		// The console input device can't be written to.
+		out = os.Stderr
+	}
+	fmt.Fprint(out, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(out)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// openTerminal opens the controlling terminal.
+func openTerminal() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.OpenFile("CONIN$", os.O_RDWR, 0)
+	}
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// netrcFile returns the path of the netrc file, as
+// specified by the $NETRC environment variable,
+// or ~/.netrc by default.
+func netrcFile() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(homeDir(), "_netrc")
+	}
+	return filepath.Join(homeDir(), ".netrc")
+}
+
+// netrcCredentials returns the login and password for
+// the host of the given URL found in the netrc file at path.
+// A machine entry matching the host name with its port
+// takes precedence over one without, and the default entry
+// is used if no machine matches. If no entry is found or
+// the file doesn't exist, it returns empty strings.
+//
+// A netrc file that can't be read or parsed is ignored
+// with a warning, so that it doesn't stop requests that
+// need no credentials.
+func netrcCredentials(path string, u *url.URL) (login, password string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warningf("ignoring netrc file: %v", err)
+		}
+		return "", ""
+	}
+	entries, err := parseNetrc(string(data))
+	if err != nil {
+		warningf("ignoring netrc file %s: %v", path, err)
+		return "", ""
+	}
+	for _, host := range []string{u.Host, u.Hostname(), ""} {
+		for _, e := range entries {
+			if e.machine == host {
+				return e.login, e.password
+			}
+		}
+	}
+	return "", ""
+}
+
+// netrcEntry holds a machine entry in a netrc file.
+// The machine is empty for the default entry.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parses the contents of a netrc file.
+func parseNetrc(data string) ([]netrcEntry, error) {
+	var entries []netrcEntry
+	var e *netrcEntry
+	inMacro := false
+	for lineNum, line := range strings.Split(data, "\n") {
+		if inMacro {
+			// A macro definition ends at an empty line.
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			if strings.HasPrefix(fields[i], "#") {
+				break
+			}
+			arg := ""
+			if i+1 < len(fields) {
+				arg = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				if arg == "" {
+					return nil, fmt.Errorf("line %d: no machine name after \"machine\"", lineNum+1)
+				}
+				entries = append(entries, netrcEntry{machine: arg})
+				e = &entries[len(entries)-1]
+				i++
+			case "default":
+				entries = append(entries, netrcEntry{})
+				e = &entries[len(entries)-1]
+			case "login", "password", "account":
+				if e == nil {
+					return nil, fmt.Errorf("line %d: %q found before any machine", lineNum+1, fields[i])
+				}
+				if arg == "" {
+					return nil, fmt.Errorf("line %d: no value after %q", lineNum+1, fields[i])
+				}
+				if fields[i] == "login" {
+					e.login = arg
+				} else if fields[i] == "password" {
+					e.password = arg
+				}
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return entries, nil
+}
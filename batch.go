@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flag "github.com/juju/gnuflag"
+)
+
+const batchHelpMessage = `usage: http batch [flag...] FILE [ARG...]
+
+Send each of the requests in FILE, or standard input if FILE is "-".
+The requests share one HTTP client and cookie jar, so that a macaroon
+discharged or cookie received by one request is used by the others.
+Each request's output is printed in turn, preceded by a line starting
+with "###", and any warnings or --expect report that it produces are
+printed to standard error at the same time. A summary of the response
+statuses and latencies is printed to standard error at the end.
+
+Each line of FILE holds one request, written with the same arguments
+as the http command, quoted as in the shell:
+
+    GET :8080/items Accept:application/json
+    POST :8080/items --json name='green apple' price:=3 --check-status
+
+Alternatively, a line may hold a JSON object with the following
+fields, all of which are optional except url or args:
+
+    {"name": "create", "method": "POST", "url": ":8080/items",
+     "headers": {"X-Request-Id": "1"}, "body": {"name": "apple"},
+     "args": ["--check-status"]}
+
+The body is sent as JSON, so it cannot be given with a GET or HEAD
+method or with request data items in args. The args hold further
+arguments, and if url is omitted they must specify the whole request.
+Blank lines and lines starting with # are ignored.
+
+Any ARGs given after FILE are added to the arguments of every request.
+This is the best way to give flags that configure the shared HTTP
+client, such as --agent, --cookiefile, --proxy and --verify, which
+must be the same for every request. Other flags, such as --follow
+and --timeout, may differ between requests.
+
+With --parallel, up to N requests are sent at once. The first request
+is always sent on its own before any others are started, so that any
+login or macaroon discharge happens only once. The output of each
+request is still printed in the order of FILE.
+
+The exit status is that of the first request that failed, if any.
+
+`
+
+// batchRequest holds a request read from a batch file.
+type batchRequest struct {
+	// label identifies the request in output and the summary.
+	label string
+
+	// args holds the command-line arguments for the request.
+	args []string
+
+	// body holds the request body, if any.
+	body []byte
+
+	req *request
+	p   *params
+
+	// output and errOutput hold the standard output and
+	// standard error produced when the request is sent,
+	// and err holds any error that it returned.
+	output    bytes.Buffer
+	errOutput bytes.Buffer
+	err       error
+
+	// result holds the outcome of sending the request.
+	result sendResult
+
+	// done is closed when the request has completed.
+	done chan struct{}
+}
+
+// batchJSONRequest holds a request written as a JSON
+// object in a batch file.
+type batchJSONRequest struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+	Args    []string          `json:"args"`
+}
+
+// batchCmd implements the batch subcommand, writing the
+// output of each request to stdout and the summary
+// to stderr.
+func batchCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("batch", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+	var parallel int
+	fset.IntVar(&parallel, "parallel", 1, "maximum number of requests to send at once")
+	fset.Usage = func() {
+		fmt.Fprint(stderr, batchHelpMessage)
+		fset.PrintDefaults()
+	}
+	// Stop at the file name so that the remaining
+	// arguments are passed to each request.
+	if err := fset.Parse(false, args); err != nil {
+		return &exitError{2}
+	}
+	args = fset.Args()
+	if len(args) == 0 {
+		fset.Usage()
+		return &exitError{2}
+	}
+	if parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	file, commonArgs := args[0], args[1:]
+	r := stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	reqs, err := readBatch(r, file)
+	if err != nil {
+		return err
+	}
+	if len(reqs) == 0 {
+		return fmt.Errorf("no requests found in %s", file)
+	}
+	printsRequest := false
+	for _, br := range reqs {
+		rfset := flag.NewFlagSet("http", flag.ContinueOnError)
+		rfset.SetOutput(ioutil.Discard)
+		// Errors are reported with the request's label
+		// instead of with the usage message.
+		rfset.Usage = func() {}
+		br.req, br.p, err = newRequest(rfset, append(br.args, commonArgs...))
+		if err == errUsage {
+			err = fmt.Errorf("no URL specified")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", br.label, err)
+		}
+		if br.p.offline || br.p.download || br.p.useStdin {
+			return fmt.Errorf("%s: cannot use --offline, --download or --stdin in a batch", br.label)
+		}
+		if br.body != nil && (len(br.req.jsonObj) > 0 || len(br.req.form) > 0 || len(br.req.files) > 0) {
+			// The body would be ignored in favour of the items.
+			return fmt.Errorf("%s: cannot use request data items with a JSON body", br.label)
+		}
+		if br.p.pretty == "" {
+			if f, ok := stdout.(*os.File); ok && isTerminal(f) {
+				br.p.colors = true
+			}
+		}
+		printsRequest = printsRequest || br.p.reqHeaders || br.p.reqBody
+	}
+	if printsRequest && parallel > 1 {
+		return fmt.Errorf("cannot print requests with --parallel")
+	}
+	for _, br := range reqs[1:] {
+		if name := differentClientFlag(reqs[0].p, br.p); name != "" {
+			return fmt.Errorf("%s: %s differs from the first request (flags that configure the HTTP client must be the same for every request)", br.label, name)
+		}
+	}
+	// All requests share the client made for the first one,
+	// which records requests if any of them need printing.
+	p0 := *reqs[0].p
+	p0.reqHeaders = printsRequest
+	jar, client, err := newClient(&p0)
+	if err != nil {
+		return fmt.Errorf("cannot make HTTP client: %v", err)
+	}
+	if jar != nil && !p0.sessionReadOnly {
+		defer jar.Save()
+	}
+	send := func(br *batchRequest) {
+		defer close(br.done)
+		var body io.Reader
+		if br.body != nil {
+			body = bytes.NewReader(br.body)
+		}
+		br.result, br.err = br.req.send(client, br.p, nil, body, &br.output, &br.errOutput)
+	}
+	go func() {
+		send(reqs[0])
+		sem := make(chan struct{}, parallel)
+		for _, br := range reqs[1:] {
+			sem <- struct{}{}
+			go func(br *batchRequest) {
+				defer func() { <-sem }()
+				send(br)
+			}(br)
+		}
+	}()
+	var firstErr error
+	for i, br := range reqs {
+		<-br.done
+		if i > 0 {
+			fmt.Fprintf(stdout, "\n")
+		}
+		fmt.Fprintf(stdout, "### %s: %s %s\n", br.label, br.req.method, br.req.url)
+		stdout.Write(br.output.Bytes())
+		if br.output.Len() > 0 && !bytes.HasSuffix(br.output.Bytes(), []byte("\n")) {
+			fmt.Fprintf(stdout, "\n")
+		}
+		stderr.Write(br.errOutput.Bytes())
+		if br.err == nil {
+			continue
+		}
+		if _, ok := br.err.(*exitError); !ok {
+			fmt.Fprintf(stderr, "%s: %v\n", br.label, br.err)
+		}
+		if firstErr == nil {
+			firstErr = br.err
+		}
+	}
+	writeBatchSummary(stderr, reqs)
+	if err, ok := firstErr.(*exitError); ok {
+		return err
+	}
+	if firstErr != nil {
+		return &exitError{1}
+	}
+	return nil
+}
+
+// clientFlags holds the flags that configure the HTTP client,
+// which is shared by all the requests in a batch, with
+// functions that return the parameters that they set.
+var clientFlags = []struct {
+	name  string
+	value func(p *params) interface{}
+}{
+	{"--agent", func(p *params) interface{} { return p.agentFile }},
+	{"--cookiefile, --no-cookies or --session", func(p *params) interface{} { return p.cookieFile }},
+	{"--session-read-only", func(p *params) interface{} { return p.sessionReadOnly }},
+	{"--no-browser", func(p *params) interface{} { return p.noBrowser }},
+	{"--login-json", func(p *params) interface{} { return p.loginJSON }},
+	{"--debug", func(p *params) interface{} { return p.debug }},
+	{"--proxy", func(p *params) interface{} { return p.proxies }},
+	{"--connect-timeout", func(p *params) interface{} { return p.connectTimeout }},
+	{"--tls-timeout", func(p *params) interface{} { return p.tlsTimeout }},
+	{"--header-timeout", func(p *params) interface{} { return p.headerTimeout }},
+	{"--verify or --insecure", func(p *params) interface{} { return []interface{}{p.insecure, p.caFiles} }},
+	{"--cert", func(p *params) interface{} { return p.certFile }},
+	{"--cert-key", func(p *params) interface{} { return p.certKeyFile }},
+	{"--cert-key-pass", func(p *params) interface{} { return p.certKeyPass }},
+	{"--ssl", func(p *params) interface{} { return p.tlsVersion }},
+	{"--ciphers", func(p *params) interface{} { return p.ciphers }},
+}
+
+// differentClientFlag returns the name of the first of
+// clientFlags that is set differently in p0 and p, or
+// the empty string if there is none.
+func differentClientFlag(p0, p *params) string {
+	for _, f := range clientFlags {
+		if !reflect.DeepEqual(f.value(p0), f.value(p)) {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// writeBatchSummary writes a table of the response
+// statuses and latencies of the given requests to w.
+func writeBatchSummary(w io.Writer, reqs []*batchRequest) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "REQUEST\tMETHOD\tURL\tSTATUS\tTIME\n")
+	for _, br := range reqs {
+		status, elapsed := br.result.status, "-"
+		if status == "" {
+			status = "error"
+		} else {
+			elapsed = br.result.elapsed.Round(time.Millisecond).String()
+		}
+		if e, ok := br.err.(*exitError); ok && br.result.status != "" {
+			status += fmt.Sprintf(" (exit %d)", e.code)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", br.label, br.req.method, br.req.url, status, elapsed)
+	}
+	tw.Flush()
+}
+
+// readBatch reads the requests in a batch file.
+// The file name is used in request labels.
+func readBatch(r io.Reader, file string) ([]*batchRequest, error) {
+	var reqs []*batchRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxMemoryBody)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		br := &batchRequest{
+			label: fmt.Sprintf("%s:%d", file, lineNum),
+			done:  make(chan struct{}),
+		}
+		var err error
+		if strings.HasPrefix(line, "{") {
+			err = br.parseJSON(line)
+		} else {
+			br.args, err = splitArgs(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", br.label, err)
+		}
+		reqs = append(reqs, br)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", file, err)
+	}
+	return reqs, nil
+}
+
+// parseJSON sets the arguments and body of br from
+// a request written as a JSON object.
+func (br *batchRequest) parseJSON(line string) error {
+	var jr batchJSONRequest
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jr); err != nil {
+		return fmt.Errorf("invalid JSON request: %v", err)
+	}
+	if jr.Name != "" {
+		br.label = jr.Name
+	}
+	if jr.URL == "" {
+		if jr.Method != "" || len(jr.Headers) > 0 || jr.Body != nil {
+			return fmt.Errorf("no url specified in JSON request")
+		}
+		br.args = jr.Args
+		return nil
+	}
+	method := jr.Method
+	if method == "" && jr.Body != nil {
+		method = "POST"
+	}
+	if jr.Body != nil && (strings.EqualFold(method, "GET") || strings.EqualFold(method, "HEAD")) {
+		return fmt.Errorf("cannot send a body with a %s request", strings.ToUpper(method))
+	}
+	if method != "" {
+		br.args = append(br.args, method)
+	}
+	br.args = append(br.args, jr.URL)
+	names := make([]string, 0, len(jr.Headers))
+	hasContentType := false
+	for name := range jr.Headers {
+		names = append(names, name)
+		hasContentType = hasContentType || strings.EqualFold(name, "Content-Type")
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		br.args = append(br.args, escapeKey(name)+":"+jr.Headers[name])
+	}
+	if jr.Body != nil {
+		if !hasContentType {
+			br.args = append(br.args, "Content-Type:application/json")
+		}
+		br.body = jr.Body
+	}
+	br.args = append(br.args, jr.Args...)
+	return nil
+}
+
+// escapeKey escapes any characters in a request item
+// key that would otherwise be taken as a separator.
+func escapeKey(key string) string {
+	var buf strings.Builder
+	for _, r := range key {
+		if strings.ContainsRune(`\:=@`, r) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// splitArgs splits a line into arguments in the manner
+// of the shell: arguments are separated by spaces,
+// single quotes preserve everything up to the
+// next single quote, and a backslash escapes the
+// following character except inside single quotes.
+// Inside double quotes, a backslash escapes only
+// a double quote or a backslash.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		case c == '\\':
+			if i+1 == len(line) {
+				return nil, fmt.Errorf("backslash at end of line")
+			}
+			i++
+			arg.WriteByte(line[i])
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			arg.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+				}
+				arg.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+		default:
+			arg.WriteByte(c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// maxMemoryBody holds the maximum number of bytes
+// of a non-seekable request body that will be held
+// in memory. Larger bodies are spooled to a temporary file.
+const maxMemoryBody = 1024 * 1024
+
+// readSeekCloser is the type of a request body that
+// can be replayed by httpbakery.Client when it needs
+// to retry a request after discharging a macaroon.
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// nopCloser implements readSeekCloser with a Close
+// method that does nothing.
+//
+// Note that we can't use ioutil.NopCloser because the
+// value it returns does not always reveal that the
+// underlying reader is seekable.
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+// Close implements io.Closer.
+func (nopCloser) Close() error {
+	return nil
+}
+
+// readCloser combines a reader with the
+// closer of a different value.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// tempFile is a readSeekCloser that removes its
+// file when closed.
+type tempFile struct {
+	*os.File
+}
+
+// Close implements io.Closer by closing and removing the file.
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// newStdinBody returns a request body that reads from
+// stdin and the number of bytes in it.
+//
+// If stdin is a regular file, the body is read directly
+// from it, starting from its current offset. Otherwise the
+// contents are read into memory if small enough, or
+// copied to a temporary file if not, so that the request
+// can be retried if needed.
+func newStdinBody(stdin io.Reader) (readSeekCloser, int64, error) {
+	if f, ok := stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, 0, err
+			}
+			size := info.Size() - offset
+			return nopCloser{io.NewSectionReader(f, offset, size)}, size, nil
+		}
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(stdin, maxMemoryBody+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) <= maxMemoryBody {
+		return nopCloser{bytes.NewReader(data)}, int64(len(data)), nil
+	}
+	f, err := ioutil.TempFile("", "bhttp-stdin")
+	if err != nil {
+		return nil, 0, err
+	}
+	body := tempFile{f}
+	size, err := io.Copy(f, io.MultiReader(bytes.NewReader(data), stdin))
+	if err != nil {
+		body.Close()
+		return nil, 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		body.Close()
+		return nil, 0, err
+	}
+	return body, size, nil
+}
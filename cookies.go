@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flag "github.com/juju/gnuflag"
+	"github.com/juju/persistent-cookiejar"
+)
+
+const cookiesHelpMessage = `usage: http cookies [flag...] list|show|delete|clear|export|import [arg...]
+
+Manage the persistent cookie jar.
+
+  list
+      List the cookies in the jar, one per line.
+
+  show [NAME...]
+      Show all the details, including the value, of the cookies
+      with the given names, or all cookies if no names are given.
+
+  delete NAME...
+      Delete the cookies with the given names.
+
+  clear
+      Delete all cookies.
+
+  export
+      Write the cookies to standard output in the format specified
+      by --format.
+
+  import [FILE]
+      Add the cookies in the given file, or standard input if
+      no file is given, to the jar. Both JSON and Netscape
+      cookies.txt formats are accepted. Session cookies, which
+      have no expiry time, are not imported.
+
+All commands act only on the cookies matched by --domain and --path.
+
+`
+
+// cookiesCmd implements the cookies subcommand.
+func cookiesCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("cookies", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+	var (
+		cookieFile string
+		filter     cookieFilter
+		format     string
+	)
+	fset.StringVar(&cookieFile, "cookiefile", cookiejar.DefaultCookieFile(), "file that persistent cookies are stored in")
+	fset.StringVar(&filter.domain, "domain", "", "act only on cookies for the given domain and its subdomains")
+	fset.StringVar(&filter.path, "path", "", "act only on cookies whose path starts with the given path")
+	fset.StringVar(&format, "format", "json", "format used by export: json or netscape")
+	fset.Usage = func() {
+		fmt.Fprint(stderr, cookiesHelpMessage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(true, args); err != nil {
+		return &exitError{2}
+	}
+	args = fset.Args()
+	if len(args) == 0 {
+		fset.Usage()
+		return &exitError{2}
+	}
+	if format != "json" && format != "netscape" {
+		return fmt.Errorf("invalid --format value %q (must be json or netscape)", format)
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{
+		Filename: cookieFile,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot open cookie jar: %v", err)
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		if len(args) > 0 {
+			return fmt.Errorf("too many arguments to list")
+		}
+		w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "DOMAIN\tPATH\tNAME\tEXPIRES\n")
+		for _, c := range filter.cookies(jar, nil) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Domain, c.Path, c.Name, c.Expires.UTC().Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "show":
+		for i, c := range filter.cookies(jar, args) {
+			if i > 0 {
+				fmt.Fprintf(stdout, "\n")
+			}
+			fmt.Fprintf(stdout, "Name: %s\n", c.Name)
+			fmt.Fprintf(stdout, "Value: %s\n", c.Value)
+			fmt.Fprintf(stdout, "Domain: %s\n", c.Domain)
+			fmt.Fprintf(stdout, "Path: %s\n", c.Path)
+			fmt.Fprintf(stdout, "Expires: %s\n", c.Expires.UTC().Format(time.RFC3339))
+			fmt.Fprintf(stdout, "Secure: %v\n", c.Secure)
+			fmt.Fprintf(stdout, "HttpOnly: %v\n", c.HttpOnly)
+		}
+		return nil
+	case "delete", "clear":
+		if cmd == "delete" && len(args) == 0 {
+			return fmt.Errorf("no cookie names given to delete")
+		}
+		if cmd == "clear" && len(args) > 0 {
+			return fmt.Errorf("too many arguments to clear")
+		}
+		for _, c := range filter.cookies(jar, args) {
+			jar.RemoveCookie(c)
+		}
+	case "export":
+		if len(args) > 0 {
+			return fmt.Errorf("too many arguments to export")
+		}
+		cookies := filter.cookies(jar, nil)
+		hostOnly, err := hostOnlyCookies(cookieFile)
+		if err != nil {
+			return fmt.Errorf("cannot read cookie file: %v", err)
+		}
+		// A leading dot marks a cookie that also
+		// matches subdomains, as when importing.
+		for _, c := range cookies {
+			if !hostOnly[cookieID(c)] {
+				c.Domain = "." + c.Domain
+			}
+		}
+		if format == "netscape" {
+			return writeNetscapeCookies(stdout, cookies)
+		}
+		return writeJSONCookies(stdout, cookies)
+	case "import":
+		if len(args) > 1 {
+			return fmt.Errorf("too many arguments to import")
+		}
+		r := stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		cookies, err := readCookies(r)
+		if err != nil {
+			return fmt.Errorf("cannot import cookies: %v", err)
+		}
+		for _, c := range cookies {
+			if !filter.match(c) {
+				continue
+			}
+			if c.Expires.IsZero() {
+				// The jar only saves cookies with an expiry time.
+				fwarningf(stderr, "not importing session cookie %q for %s", c.Name, strings.TrimPrefix(c.Domain, "."))
+				continue
+			}
+			u, c := cookieURL(c)
+			jar.SetCookies(u, []*http.Cookie{c})
+		}
+	default:
+		return fmt.Errorf("unknown cookies command %q", cmd)
+	}
+	if err := jar.Save(); err != nil {
+		return fmt.Errorf("cannot save cookies: %v", err)
+	}
+	return nil
+}
+
+// cookieFilter selects cookies by domain and path.
+type cookieFilter struct {
+	domain string
+	path   string
+}
+
+// match reports whether the filter matches the given cookie.
+func (f cookieFilter) match(c *http.Cookie) bool {
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	if fd := strings.ToLower(strings.TrimPrefix(f.domain, ".")); fd != "" {
+		if domain != fd && !strings.HasSuffix(domain, "."+fd) {
+			return false
+		}
+	}
+	return strings.HasPrefix(c.Path, f.path)
+}
+
+// cookies returns all the cookies in the jar that match the filter.
+// If names is non-empty, only cookies with one of the
+// given names are returned.
+func (f cookieFilter) cookies(jar *cookiejar.Jar, names []string) []*http.Cookie {
+	var cookies []*http.Cookie
+	for _, c := range jar.AllCookies() {
+		if f.match(c) && (len(names) == 0 || contains(names, c.Name)) {
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
+
+func contains(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieID returns a string that identifies the given cookie
+// in the jar, which holds at most one cookie with a given
+// domain, path and name.
+func cookieID(c *http.Cookie) string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+// hostOnlyCookies returns the ids, as returned by cookieID,
+// of the host-only cookies stored in the given cookie file.
+// The jar doesn't make this available, so the file is read
+// directly.
+func hostOnlyCookies(cookieFile string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(cookieFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []struct {
+		Name     string
+		Domain   string
+		Path     string
+		HostOnly bool
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+	}
+	hostOnly := make(map[string]bool)
+	for _, e := range entries {
+		if e.HostOnly {
+			hostOnly[cookieID(&http.Cookie{Name: e.Name, Domain: e.Domain, Path: e.Path})] = true
+		}
+	}
+	return hostOnly, nil
+}
+
+// cookieURL returns a URL that the given cookie can be set for,
+// and the cookie to set. An empty domain in the returned cookie
+// makes it a host-only cookie.
+func cookieURL(c *http.Cookie) (*url.URL, *http.Cookie) {
+	c1 := *c
+	scheme := "http"
+	if c.Secure {
+		scheme = "https"
+	}
+	u := &url.URL{
+		Scheme: scheme,
+		Host:   strings.TrimPrefix(c.Domain, "."),
+		Path:   c.Path,
+	}
+	if !strings.HasPrefix(c.Domain, ".") {
+		c1.Domain = ""
+	}
+	return u, &c1
+}
+
+// jsonCookie is the form used to store a cookie in
+// the JSON import and export format.
+type jsonCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"httpOnly,omitempty"`
+}
+
+// writeJSONCookies writes the given cookies to w as a JSON array.
+func writeJSONCookies(w io.Writer, cookies []*http.Cookie) error {
+	jcookies := make([]jsonCookie, len(cookies))
+	for i, c := range cookies {
+		jcookies[i] = jsonCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires.UTC(),
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		}
+	}
+	data, err := json.MarshalIndent(jcookies, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeNetscapeCookies writes the given cookies to w
+// in Netscape cookies.txt format. A cookie whose domain
+// has a leading dot also matches subdomains.
+func writeNetscapeCookies(w io.Writer, cookies []*http.Cookie) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range cookies {
+		domain := c.Domain
+		if c.HttpOnly {
+			domain = netscapeHttpOnlyPrefix + domain
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain,
+			netscapeBool(strings.HasPrefix(c.Domain, ".")),
+			c.Path,
+			netscapeBool(c.Secure),
+			c.Expires.Unix(),
+			c.Name,
+			c.Value,
+		)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// netscapeHttpOnlyPrefix is prefixed to the domain of
+// HttpOnly cookies in cookies.txt files.
+const netscapeHttpOnlyPrefix = "#HttpOnly_"
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// readCookies reads cookies in either JSON or Netscape
+// cookies.txt format from r.
+func readCookies(r io.Reader) ([]*http.Cookie, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var jcookies []jsonCookie
+		if err := json.Unmarshal(trimmed, &jcookies); err != nil {
+			return nil, err
+		}
+		cookies := make([]*http.Cookie, len(jcookies))
+		for i, c := range jcookies {
+			cookies[i] = &http.Cookie{
+				Name:     c.Name,
+				Value:    c.Value,
+				Domain:   c.Domain,
+				Path:     c.Path,
+				Expires:  c.Expires,
+				Secure:   c.Secure,
+				HttpOnly: c.HttpOnly,
+			}
+		}
+		return cookies, nil
+	}
+	return parseNetscapeCookies(data)
+}
+
+// parseNetscapeCookies parses cookies in Netscape cookies.txt format.
+func parseNetscapeCookies(data []byte) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, netscapeHttpOnlyPrefix)
+		line = strings.TrimPrefix(line, netscapeHttpOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNum, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry time %q", lineNum, fields[4])
+		}
+		domain := fields[0]
+		if fields[1] == "TRUE" && !strings.HasPrefix(domain, ".") {
+			domain = "." + domain
+		} else if fields[1] != "TRUE" {
+			domain = strings.TrimPrefix(domain, ".")
+		}
+		c := &http.Cookie{
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   fields[3] == "TRUE",
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
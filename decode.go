@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding holds the value of the Accept-Encoding
+// header sent when --compress is specified. It should
+// include all the codings that decodeBody knows about.
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// decodeBody returns a reader that reads the body
+// with any content codings listed in the Content-Encoding
+// header of h removed.
+//
+// If the body can't be decoded, it returns the error along with
+// a reader that reads the whole of the undecoded body, including
+// any data read while trying to decode it.
+func decodeBody(h http.Header, body io.Reader) (io.Reader, error) {
+	var codings []string
+	for _, v := range h["Content-Encoding"] {
+		for _, coding := range strings.Split(v, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	if len(codings) == 0 {
+		return body, nil
+	}
+	// Record the data read by the decoders as they're
+	// created so that it can be replayed if one fails.
+	raw := &recordingReader{r: body}
+	body = raw
+	// Codings are listed in the order they were applied,
+	// so remove them in reverse order.
+	for i := len(codings) - 1; i >= 0; i-- {
+		r, err := decoder(codings[i], body)
+		if err != nil {
+			return io.MultiReader(&raw.buf, raw.r), fmt.Errorf("cannot decode %s content: %v", codings[i], err)
+		}
+		body = r
+	}
+	raw.buf = bytes.Buffer{}
+	raw.done = true
+	return body, nil
+}
+
+// recordingReader reads from r, keeping a copy
+// of the data read in buf until done is set.
+type recordingReader struct {
+	r    io.Reader
+	buf  bytes.Buffer
+	done bool
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if !r.done {
+		r.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// decoder returns a reader that decodes r
+// with the given content coding.
+func decoder(coding string, r io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// The deflate coding is defined to be zlib-wrapped
+		// (RFC 2616 section 3.5) but some servers send raw
+		// deflate data, so accept both.
+		br := bufio.NewReader(r)
+		if hdr, err := br.Peek(2); err == nil && isZlibHeader(hdr) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported content coding")
+}
+
+// isZlibHeader reports whether hdr holds a valid
+// zlib stream header (RFC 1950 section 2.2).
+func isZlibHeader(hdr []byte) bool {
+	return hdr[0]&0x0f == 8 && (uint(hdr[0])<<8|uint(hdr[1]))%31 == 0
+}
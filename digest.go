@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// digestAuth implements authProvider for HTTP
+// digest authentication (RFC 7616).
+type digestAuth struct {
+	username string
+	password string
+
+	// chal holds the challenge from the server,
+	// or nil if there has been none yet.
+	chal *challenge
+}
+
+// newDigestAuth returns a provider that uses HTTP digest authentication.
+func newDigestAuth(p *params) (authProvider, error) {
+	username, password, err := userPassword(p)
+	if err != nil || username == "" {
+		return nil, err
+	}
+	return &digestAuth{
+		username: username,
+		password: password,
+	}, nil
+}
+
+// prepare implements authProvider.prepare by answering
+// the server's challenge, if there has been one.
+func (d *digestAuth) prepare(ctx context.Context, client *http.Client, req *http.Request) error {
+	if d.chal == nil {
+		return nil
+	}
+	body, _ := req.Body.(io.ReadSeeker)
+	auth, err := d.authorization(d.chal, req.Method, req.URL.RequestURI(), body)
+	if err != nil {
+		return err
+	}
+	if err := rewind(body); err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	return nil
+}
+
+// challenge implements authProvider.challenge by choosing
+// the digest challenge to answer, if there is one.
+func (d *digestAuth) challenge(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response) (bool, error) {
+	d.chal = chooseDigestChallenge(parseChallenges(resp.Header["Www-Authenticate"]))
+	return d.chal != nil, nil
+}
+
+func (d *digestAuth) save(resp *http.Response) error {
+	return nil
+}
+
+// challenge holds an authentication challenge
+// from a WWW-Authenticate header.
+type challenge struct {
+	// scheme holds the authentication scheme,
+	// such as "Digest", in lower case.
+	scheme string
+
+	// params holds the challenge's parameters,
+	// keyed by lower case name.
+	params map[string]string
+}
+
+// parseChallenges parses the given WWW-Authenticate header
+// values, each of which may hold several challenges.
+func parseChallenges(hdrs []string) []challenge {
+	var chals []challenge
+	for _, h := range hdrs {
+		for h != "" {
+			h = strings.TrimLeft(h, " \t,")
+			n := strings.IndexAny(h, " \t,=")
+			if n == -1 {
+				n = len(h)
+			}
+			if n == 0 {
+				break
+			}
+			tok := h[:n]
+			h = strings.TrimLeft(h[n:], " \t")
+			if !strings.HasPrefix(h, "=") {
+				chals = append(chals, challenge{
+					scheme: strings.ToLower(tok),
+					params: make(map[string]string),
+				})
+				continue
+			}
+			var val string
+			val, h = parseParamValue(strings.TrimLeft(h[1:], " \t"))
+			if len(chals) > 0 {
+				chals[len(chals)-1].params[strings.ToLower(tok)] = val
+			}
+		}
+	}
+	return chals
+}
+
+// parseParamValue parses a token or quoted string at the start
+// of s and returns its value and the rest of s.
+func parseParamValue(s string) (val, rest string) {
+	if !strings.HasPrefix(s, `"`) {
+		n := strings.IndexAny(s, " \t,")
+		if n == -1 {
+			n = len(s)
+		}
+		return s[:n], s[n:]
+	}
+	var buf strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				buf.WriteByte(s[i])
+			}
+		case '"':
+			return buf.String(), s[i+1:]
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), ""
+}
+
+// digestAlgorithms holds the hash functions for the
+// supported digest algorithms, in increasing order of
+// preference. The -sess variants use the same functions.
+var digestAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"MD5", md5.New},
+	{"SHA-256", sha256.New},
+	{"SHA-512-256", sha512.New512_256},
+}
+
+// digestAlgorithm returns the index in digestAlgorithms of
+// the given algorithm and whether it's a session variant.
+// It returns -1 if the algorithm is not supported.
+func digestAlgorithm(alg string) (index int, sess bool) {
+	if alg == "" {
+		alg = "MD5"
+	}
+	alg = strings.ToUpper(alg)
+	if strings.HasSuffix(alg, "-SESS") {
+		alg, sess = strings.TrimSuffix(alg, "-SESS"), true
+	}
+	for i, a := range digestAlgorithms {
+		if a.name == alg {
+			return i, sess
+		}
+	}
+	return -1, false
+}
+
+// chooseDigestChallenge returns the digest challenge with the
+// strongest supported algorithm, or nil if there is none.
+func chooseDigestChallenge(chals []challenge) *challenge {
+	var best *challenge
+	bestIndex := -1
+	for i, ch := range chals {
+		if ch.scheme != "digest" || ch.params["nonce"] == "" {
+			continue
+		}
+		if index, _ := digestAlgorithm(ch.params["algorithm"]); index > bestIndex {
+			best, bestIndex = &chals[i], index
+		}
+	}
+	return best
+}
+
+// authorization returns the value of the Authorization header
+// that answers the given digest challenge for a request with the
+// given method, URI and body, which may be nil.
+func (d *digestAuth) authorization(ch *challenge, method, uri string, body io.ReadSeeker) (string, error) {
+	index, sess := digestAlgorithm(ch.params["algorithm"])
+	if index == -1 {
+		return "", fmt.Errorf("unsupported digest algorithm %q", ch.params["algorithm"])
+	}
+	newHash := digestAlgorithms[index].hash
+	h := func(s string) string {
+		hh := newHash()
+		io.WriteString(hh, s)
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	realm, nonce := ch.params["realm"], ch.params["nonce"]
+	qop := ""
+	for _, q := range strings.Split(ch.params["qop"], ",") {
+		q = strings.TrimSpace(q)
+		if q == "auth" || q == "auth-int" && qop == "" {
+			qop = q
+		}
+	}
+	if ch.params["qop"] != "" && qop == "" {
+		return "", fmt.Errorf("unsupported digest qop %q", ch.params["qop"])
+	}
+	cnonce, err := newCnonce()
+	if err != nil {
+		return "", err
+	}
+	const nc = "00000001"
+	ha1 := h(d.username + ":" + realm + ":" + d.password)
+	if sess {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	a2 := method + ":" + uri
+	if qop == "auth-int" {
+		bodyHash := newHash()
+		if body != nil {
+			if _, err := io.Copy(bodyHash, body); err != nil {
+				return "", fmt.Errorf("cannot read request body: %v", err)
+			}
+		}
+		a2 += ":" + hex.EncodeToString(bodyHash.Sum(nil))
+	}
+	var response string
+	if qop == "" {
+		// RFC 2069 compatibility.
+		response = h(ha1 + ":" + nonce + ":" + h(a2))
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + h(a2))
+	}
+	username := d.username
+	if strings.EqualFold(ch.params["userhash"], "true") {
+		username = h(d.username + ":" + realm)
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Digest username=%s, realm=%s, uri=%s", quote(username), quote(realm), quote(uri))
+	if alg := ch.params["algorithm"]; alg != "" {
+		fmt.Fprintf(&buf, ", algorithm=%s", alg)
+	}
+	fmt.Fprintf(&buf, ", nonce=%s", quote(nonce))
+	if qop != "" {
+		fmt.Fprintf(&buf, ", nc=%s, cnonce=%s, qop=%s", nc, quote(cnonce), qop)
+	}
+	fmt.Fprintf(&buf, ", response=%s", quote(response))
+	if opaque, ok := ch.params["opaque"]; ok {
+		fmt.Fprintf(&buf, ", opaque=%s", quote(opaque))
+	}
+	if strings.EqualFold(ch.params["userhash"], "true") {
+		buf.WriteString(", userhash=true")
+	}
+	return buf.String(), nil
+}
+
+// newCnonce returns a new client nonce.
+// It's a variable so that it can be replaced in tests.
+var newCnonce = func() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// quote returns s as an HTTP quoted string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
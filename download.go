@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// download holds a download in progress, as
+// requested by the --download flag.
+type download struct {
+	p *params
+
+	// offset holds the size of the partial file
+	// that is being resumed, or zero if the
+	// download starts from the beginning.
+	offset int64
+}
+
+// newDownload prepares a download of the given request.
+// If a partial download is being continued, it adds
+// a Range header to the request.
+func newDownload(p *params, req *request) (*download, error) {
+	d := &download{
+		p: p,
+	}
+	if !p.resume {
+		return d, nil
+	}
+	info, err := os.Stat(p.output)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return d, nil
+		}
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", p.output)
+	}
+	d.offset = info.Size()
+	if d.offset > 0 {
+		req.header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+	}
+	return d, nil
+}
+
+// save writes the body of the given response to the download file,
+// printing progress to stderr.
+func (d *download) save(resp *http.Response, stderr io.Writer) error {
+	if d.p.headers {
+		printStatusLine(stderr, resp, noColors)
+		printHeaders(stderr, resp.Header, noColors)
+		fmt.Fprintf(stderr, "\n")
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && d.offset > 0:
+		start, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return fmt.Errorf("cannot resume download: %v", err)
+		}
+		if start != d.offset {
+			return fmt.Errorf("cannot resume download: server sent content from offset %d, not %d", start, d.offset)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && d.offset > 0:
+		_, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && total == d.offset {
+			fmt.Fprintf(stderr, "%s is already complete\n", d.p.output)
+			return nil
+		}
+		return fmt.Errorf("cannot resume download: %s", resp.Status)
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("cannot download: %s", resp.Status)
+	case d.offset > 0:
+		fwarningf(stderr, "server does not support resuming downloads; downloading whole file")
+		d.offset = 0
+	}
+	filename := d.p.output
+	if filename == "" {
+		filename = uniqueFilename(downloadFilename(resp))
+	}
+	f, err := os.OpenFile(filename, flags, 0666)
+	if err != nil {
+		return err
+	}
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = d.offset + resp.ContentLength
+	}
+	pw := &progressWriter{
+		w:        stderr,
+		done:     d.offset,
+		total:    total,
+		terminal: isTerminalWriter(stderr),
+	}
+	n, err := io.Copy(f, io.TeeReader(resp.Body, pw))
+	pw.finish()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %v", filename, err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("incomplete download: got %d bytes of %d; use --continue to resume", n, resp.ContentLength)
+	}
+	fmt.Fprintf(stderr, "downloaded %s to %s\n", formatSize(d.offset+n), filename)
+	return nil
+}
+
+// parseContentRange parses the value of a Content-Range header
+// and returns the starting offset and the total size of the content.
+// The total is -1 if it's unknown.
+func parseContentRange(s string) (start, total int64, err error) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	i := strings.Index(s, "/")
+	if i == -1 {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	rangeStr, totalStr := s[:i], s[i+1:]
+	total = -1
+	if totalStr != "*" {
+		total, err = strconv.ParseInt(totalStr, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+		}
+	}
+	if rangeStr == "*" {
+		return 0, total, nil
+	}
+	i = strings.Index(rangeStr, "-")
+	if i == -1 {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	start, err = strconv.ParseInt(rangeStr[:i], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return start, total, nil
+}
+
+// downloadFilename returns the name of the file to save
+// the response body in, taken from the Content-Disposition
+// header if present, or the URL path otherwise.
+func downloadFilename(resp *http.Response) string {
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil {
+			if name := sanitizeFilename(params["filename"]); name != "" {
+				return name
+			}
+		}
+	}
+	name := ""
+	if resp.Request != nil {
+		name = sanitizeFilename(path.Base(resp.Request.URL.Path))
+	}
+	if name == "" {
+		name = "index"
+	}
+	if filepath.Ext(name) == "" {
+		if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+	}
+	return name
+}
+
+// sanitizeFilename returns the final element of the given
+// name so that a server cannot cause a file to be
+// written outside the current directory. It returns
+// the empty string if there's no usable name.
+func sanitizeFilename(name string) string {
+	name = filepath.Base(filepath.FromSlash(strings.Replace(name, "\\", "/", -1)))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return ""
+	}
+	return name
+}
+
+// uniqueFilename returns a name based on the given
+// name that does not refer to an existing file.
+func uniqueFilename(name string) string {
+	if _, err := os.Lstat(name); os.IsNotExist(err) {
+		return name
+	}
+	for i := 1; ; i++ {
+		alt := fmt.Sprintf("%s-%d", name, i)
+		if _, err := os.Lstat(alt); os.IsNotExist(err) {
+			return alt
+		}
+	}
+}
+
+// progressWriter counts the bytes written to it
+// and prints a progress bar when writing to a terminal.
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	total    int64
+	terminal bool
+	last     time.Time
+}
+
+const progressBarWidth = 30
+
+func (pw *progressWriter) Write(buf []byte) (int, error) {
+	pw.done += int64(len(buf))
+	if pw.terminal && time.Since(pw.last) >= 100*time.Millisecond {
+		pw.print()
+		pw.last = time.Now()
+	}
+	return len(buf), nil
+}
+
+func (pw *progressWriter) print() {
+	if pw.total <= 0 {
+		fmt.Fprintf(pw.w, "\r%s", formatSize(pw.done))
+		return
+	}
+	n := int(pw.done * progressBarWidth / pw.total)
+	if n > progressBarWidth {
+		n = progressBarWidth
+	}
+	fmt.Fprintf(pw.w, "\r[%s%s] %3d%% %s / %s",
+		strings.Repeat("=", n),
+		strings.Repeat(" ", progressBarWidth-n),
+		pw.done*100/pw.total,
+		formatSize(pw.done),
+		formatSize(pw.total),
+	)
+}
+
+// finish prints the final state of the progress bar.
+func (pw *progressWriter) finish() {
+	if pw.terminal {
+		pw.print()
+		fmt.Fprintf(pw.w, "\n")
+	}
+}
+
+// formatSize returns n formatted as a human-readable size.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminalWriter reports whether w is a file
+// that refers to a terminal.
+func isTerminalWriter(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f)
+}
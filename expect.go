@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exitExpectFailed is the exit code used when
+// an --expect assertion fails.
+const exitExpectFailed = 8
+
+// expectation holds an assertion about the response,
+// as specified with --expect. For example:
+//
+//	status=201
+//	header:Content-Type~json
+//	body.items[0].id=42
+//	time<500ms
+type expectation struct {
+	// text holds the assertion as specified.
+	text string
+
+	// subject holds what the assertion is about: one of
+	// "status", "header", "body" or "time".
+	subject string
+
+	// header holds the header name for a header assertion.
+	header string
+
+	// path holds the filter that selects the value within
+	// the JSON body for a body assertion, if any.
+	path *jsonFilter
+
+	// op holds the comparison operator.
+	op string
+
+	// value holds the value to compare against.
+	value string
+
+	// re holds the compiled value for the ~ and !~ operators.
+	re *regexp.Regexp
+
+	// duration holds the parsed value for a time assertion.
+	duration time.Duration
+}
+
+// expectOps holds the comparison operators allowed in expectations.
+var expectOps = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+// expectValue implements flag.Value for the --expect flag,
+// which may be given more than once.
+type expectValue struct {
+	expects *[]expectation
+}
+
+func (v expectValue) String() string {
+	if v.expects == nil {
+		return ""
+	}
+	var ss []string
+	for _, e := range *v.expects {
+		ss = append(ss, e.text)
+	}
+	return strings.Join(ss, ",")
+}
+
+func (v expectValue) Set(s string) error {
+	e, err := parseExpectation(s)
+	if err != nil {
+		return err
+	}
+	*v.expects = append(*v.expects, e)
+	return nil
+}
+
+// parseExpectation parses an assertion of the form
+// SUBJECT OP VALUE. A body path is written as for --filter.
+func parseExpectation(s string) (expectation, error) {
+	e := expectation{
+		text: s,
+	}
+	i := expectOpIndex(s)
+	if i <= 0 {
+		return expectation{}, fmt.Errorf("invalid expectation %q (must be of the form SUBJECT OP VALUE)", s)
+	}
+	e.op = matchOp(s[i:], expectOps)
+	if e.op == "" {
+		return expectation{}, fmt.Errorf("invalid operator in expectation %q", s)
+	}
+	subject := s[:i]
+	e.value = s[i+len(e.op):]
+	switch {
+	case subject == "status" || subject == "time" || subject == "body":
+		e.subject = subject
+	case strings.HasPrefix(subject, "header:") && len(subject) > len("header:"):
+		e.subject, e.header = "header", subject[len("header:"):]
+	case strings.HasPrefix(subject, "body.") || strings.HasPrefix(subject, "body["):
+		path, err := parseFilter(subject[len("body"):])
+		if err != nil {
+			return expectation{}, fmt.Errorf("invalid expectation %q: invalid body path: %v", s, err)
+		}
+		e.subject, e.path = "body", path
+	default:
+		return expectation{}, fmt.Errorf("invalid expectation %q: unknown subject %q (must be status, time, body, body.PATH or header:NAME)", s, subject)
+	}
+	switch {
+	case e.op == "~" || e.op == "!~":
+		if e.subject == "time" {
+			return expectation{}, fmt.Errorf("invalid expectation %q: cannot use %s with time", s, e.op)
+		}
+		re, err := regexp.Compile(e.value)
+		if err != nil {
+			return expectation{}, fmt.Errorf("invalid expectation %q: %v", s, err)
+		}
+		e.re = re
+	case e.subject == "time":
+		if err := (timeoutValue{&e.duration}).Set(e.value); err != nil {
+			return expectation{}, fmt.Errorf("invalid expectation %q: invalid duration %q", s, e.value)
+		}
+	}
+	return e, nil
+}
+
+// expectOpIndex returns the index of the operator in the
+// expectation s, or -1 if there is none. Operator characters
+// inside brackets are part of the subject, so that a body path
+// may hold a predicate such as [?(@.id>1)].
+func expectOpIndex(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '[':
+			end := closingBracket(s[i:])
+			if end == -1 {
+				// Leave the missing bracket to be
+				// reported when the path is parsed.
+				end = strings.IndexAny(s[i:], "=!~<>")
+				if end == -1 {
+					return -1
+				}
+				return i + end
+			}
+			i += end
+		case strings.IndexByte("=!~<>", s[i]) >= 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// needsBody reports whether any of the given
+// expectations are about the response body.
+func needsBody(expects []expectation) bool {
+	for _, e := range expects {
+		if e.subject == "body" {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferBody reads the whole of the response body so that it can
+// be checked by --expect assertions, replaces the response body
+// with a reader of the same data, and returns the body with any
+// content codings removed.
+func bufferBody(resp *http.Response) ([]byte, error) {
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response body: %v", err)
+	}
+	r, err := decodeBody(resp.Header, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// expectResponse checks the response against the assertions
+// given with --expect and writes a report to w. The body holds the
+// decoded response body, and elapsed holds the time taken to get
+// the response. It returns an error with the exitExpectFailed code
+// if any assertion fails.
+func expectResponse(w io.Writer, expects []expectation, resp *http.Response, body []byte, elapsed time.Duration) error {
+	failed := 0
+	for _, e := range expects {
+		if msg := e.check(resp, body, elapsed); msg != "" {
+			fmt.Fprintf(w, "FAIL %s (%s)\n", e.text, msg)
+			failed++
+		} else {
+			fmt.Fprintf(w, "PASS %s\n", e.text)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "%d of %d expectations failed\n", failed, len(expects))
+		return &exitError{exitExpectFailed}
+	}
+	return nil
+}
+
+// check checks the expectation against the response. It returns
+// the empty string if it passes, or a description of the actual
+// value otherwise.
+func (e *expectation) check(resp *http.Response, body []byte, elapsed time.Duration) string {
+	switch e.subject {
+	case "status":
+		if (e.op == "=" || e.op == "!=") && isStatusClass(e.value) {
+			class := strconv.Itoa(resp.StatusCode/100) + "xx"
+			return e.result((class == strings.ToLower(e.value)) == (e.op == "="), "got "+resp.Status)
+		}
+		return e.compare(float64(resp.StatusCode), "got "+resp.Status)
+	case "header":
+		vals, ok := resp.Header[http.CanonicalHeaderKey(e.header)]
+		if !ok {
+			return e.result(e.op == "!=" || e.op == "!~", "header not present")
+		}
+		val := strings.Join(vals, ", ")
+		return e.compare(val, fmt.Sprintf("got %q", val))
+	case "time":
+		ok := false
+		switch e.op {
+		case "=":
+			ok = elapsed == e.duration
+		case "!=":
+			ok = elapsed != e.duration
+		case "<":
+			ok = elapsed < e.duration
+		case "<=":
+			ok = elapsed <= e.duration
+		case ">":
+			ok = elapsed > e.duration
+		case ">=":
+			ok = elapsed >= e.duration
+		}
+		return e.result(ok, "took "+elapsed.String())
+	case "body":
+		if body == nil {
+			return "body not available"
+		}
+		if e.path == nil {
+			return e.compare(string(body), fmt.Sprintf("got %q", truncate(string(body), 40)))
+		}
+		if !json.Valid(body) {
+			return "body is not valid JSON"
+		}
+		vals, err := e.path.apply(body)
+		if err != nil {
+			return err.Error()
+		}
+		if len(vals) == 0 {
+			return e.result(e.op == "!=" || e.op == "!~", "not found")
+		}
+		// A path that selects more than one value
+		// is compared as an array of those values.
+		data, _ := json.Marshal(vals)
+		if len(vals) == 1 {
+			data = vals[0]
+		}
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err.Error()
+		}
+		data, _ = json.Marshal(v)
+		return e.compare(v, "got "+truncate(string(data), 40))
+	}
+	panic("unreachable")
+}
+
+// compare compares the actual value, which may be a number, a
+// string or another JSON value, with the expected value. It
+// returns the result as for check, using got to describe the
+// actual value.
+func (e *expectation) compare(actual interface{}, got string) string {
+	switch e.op {
+	case "~", "!~":
+		s, ok := actual.(string)
+		if !ok {
+			data, _ := json.Marshal(actual)
+			s = string(data)
+		}
+		return e.result(e.re.MatchString(s) == (e.op == "~"), got)
+	case "=", "!=":
+		return e.result(expectEqual(actual, e.value) == (e.op == "="), got)
+	}
+	x, ok := toNumber(actual)
+	if !ok {
+		return "not a number; " + got
+	}
+	y, err := strconv.ParseFloat(e.value, 64)
+	if err != nil {
+		return fmt.Sprintf("cannot compare with non-number %q", e.value)
+	}
+	switch e.op {
+	case "<":
+		ok = x < y
+	case "<=":
+		ok = x <= y
+	case ">":
+		ok = x > y
+	case ">=":
+		ok = x >= y
+	}
+	return e.result(ok, got)
+}
+
+// result returns the result of check given whether the expectation
+// passed and a description of the actual value.
+func (e *expectation) result(ok bool, got string) string {
+	if ok {
+		return ""
+	}
+	return got
+}
+
+// expectEqual reports whether the actual value is equal to the
+// expected value. The expected value is compared as JSON if it's
+// valid JSON, so that, for example, 42 matches the number 42;
+// otherwise it's compared as a string.
+func expectEqual(actual interface{}, expected string) bool {
+	if s, ok := actual.(string); ok && s == expected {
+		return true
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(expected), &v); err != nil {
+		return false
+	}
+	if f, ok := actual.(float64); ok {
+		// Numbers may be written in more than one way.
+		g, ok := v.(float64)
+		return ok && f == g
+	}
+	return reflect.DeepEqual(actual, v)
+}
+
+// toNumber returns the numeric value of v, which
+// may be a number or a string holding a number.
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// isStatusClass reports whether s is a status class such as "2xx".
+func isStatusClass(s string) bool {
+	return len(s) == 3 && '1' <= s[0] && s[0] <= '5' && strings.ToLower(s[1:]) == "xx"
+}
+
+// truncate returns s truncated to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// jsonFilter holds a filter that selects values from a JSON
+// document, as specified with --filter. The syntax is a subset
+// of JSONPath and jq:
+//
+//	.items[0].id       field access and array indexes
+//	.items[-1]         indexes counted from the end
+//	.items.0           a field made of digits selects an array element
+//	.items[*].id       all elements of an array or values of an object
+//	.items[]           likewise
+//	.items[1:3]        a slice of an array
+//	.items[?(@.id>1)]  the elements that satisfy a predicate
+//	["a key"]          a field name that needs quoting
+//
+// The expression may start with "$" or ".", and "." on its
+// own selects the whole document. Wildcards, slices and
+// predicates may select more than one value.
+type jsonFilter struct {
+	steps []filterStep
+}
+
+// filterStep holds one step of a filter expression.
+type filterStep struct {
+	kind filterStepKind
+
+	// field holds the field name for stepField.
+	field string
+
+	// index holds the array index for stepIndex.
+	index int
+
+	// start and end hold the slice bounds for stepSlice,
+	// or nil if they're omitted.
+	start, end *int
+
+	// pred holds the predicate for stepPredicate.
+	pred *filterPredicate
+}
+
+type filterStepKind int
+
+const (
+	stepField filterStepKind = iota
+	stepIndex
+	stepWildcard
+	stepSlice
+	stepPredicate
+)
+
+// filterPredicate holds a predicate such as @.id>1. If op
+// is empty, the predicate holds when the path selects a
+// value that is not null or false.
+type filterPredicate struct {
+	path  []filterStep
+	op    string
+	value interface{}
+}
+
+// predicateOps holds the comparison operators allowed in predicates.
+var predicateOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// matchOp returns the first of ops that s starts with, or the
+// empty string if there is none. An operator must be listed
+// before any shorter operator that it starts with.
+func matchOp(s string, ops []string) string {
+	for _, op := range ops {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// parseFilter parses a filter expression.
+func parseFilter(expr string) (*jsonFilter, error) {
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+	if s == "." {
+		s = ""
+	}
+	steps, err := parseFilterSteps(s, false)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonFilter{steps}, nil
+}
+
+// parseFilterSteps parses the steps of a filter expression.
+// If simple is true, only fields and indexes are allowed,
+// as used in predicates.
+func parseFilterSteps(s string, simple bool) ([]filterStep, error) {
+	var steps []filterStep
+	first := true
+	for s != "" {
+		switch {
+		case s[0] == '[':
+			end := closingBracket(s)
+			if end == -1 {
+				return nil, fmt.Errorf("missing ']'")
+			}
+			step, err := parseBracketStep(strings.TrimSpace(s[1:end]))
+			if err != nil {
+				return nil, err
+			}
+			if simple && step.kind != stepField && step.kind != stepIndex {
+				return nil, fmt.Errorf("only fields and indexes are allowed in predicates")
+			}
+			steps = append(steps, step)
+			s = s[end+1:]
+		case s[0] == '.' || first:
+			if s[0] == '.' {
+				s = s[1:]
+			}
+			if strings.HasPrefix(s, "*") {
+				if simple {
+					return nil, fmt.Errorf("only fields and indexes are allowed in predicates")
+				}
+				steps = append(steps, filterStep{kind: stepWildcard})
+				s = s[1:]
+				break
+			}
+			n := strings.IndexAny(s, ".[")
+			if n == -1 {
+				n = len(s)
+			}
+			if n == 0 {
+				if s == "" || s[0] == '.' {
+					return nil, fmt.Errorf("empty field name")
+				}
+				// A bracket directly after a dot, as in jq's .[0].
+				break
+			}
+			steps = append(steps, filterStep{kind: stepField, field: s[:n]})
+			s = s[n:]
+		default:
+			return nil, fmt.Errorf("unexpected text %q", s)
+		}
+		first = false
+	}
+	return steps, nil
+}
+
+// closingBracket returns the index of the ']' that closes the '['
+// at the start of s, allowing for quoted strings and nested
+// brackets, or -1 if there is none.
+func closingBracket(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseBracketStep parses the contents of a bracketed step.
+func parseBracketStep(s string) (filterStep, error) {
+	switch {
+	case s == "" || s == "*":
+		return filterStep{kind: stepWildcard}, nil
+	case s[0] == '?':
+		pred, err := parsePredicate(strings.TrimSpace(s[1:]))
+		if err != nil {
+			return filterStep{}, err
+		}
+		return filterStep{kind: stepPredicate, pred: pred}, nil
+	case s[0] == '"' || s[0] == '\'':
+		v, err := parseFilterLiteral(s)
+		if err != nil {
+			return filterStep{}, err
+		}
+		field, ok := v.(string)
+		if !ok {
+			return filterStep{}, fmt.Errorf("invalid field name %s", s)
+		}
+		return filterStep{kind: stepField, field: field}, nil
+	case strings.Contains(s, ":"):
+		parts := strings.SplitN(s, ":", 2)
+		step := filterStep{kind: stepSlice}
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return filterStep{}, fmt.Errorf("invalid slice %q", s)
+			}
+			if i == 0 {
+				step.start = &n
+			} else {
+				step.end = &n
+			}
+		}
+		return step, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return filterStep{}, fmt.Errorf("invalid index %q", s)
+	}
+	return filterStep{kind: stepIndex, index: n}, nil
+}
+
+// parsePredicate parses a predicate such as (@.id > 1),
+// with or without the parentheses.
+func parsePredicate(s string) (*filterPredicate, error) {
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	if !strings.HasPrefix(s, "@") {
+		return nil, fmt.Errorf("predicate %q must start with @", s)
+	}
+	pred := &filterPredicate{}
+	path := s[1:]
+	for i := 0; i < len(s) && pred.op == ""; i++ {
+		if s[i] == '"' || s[i] == '\'' {
+			// Quoted field names may hold operator characters.
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end == -1 {
+				break
+			}
+			i += end + 1
+			continue
+		}
+		if op := matchOp(s[i:], predicateOps); op != "" {
+			v, err := parseFilterLiteral(strings.TrimSpace(s[i+len(op):]))
+			if err != nil {
+				return nil, err
+			}
+			pred.op, pred.value = op, v
+			path = strings.TrimSpace(s[1:i])
+		}
+	}
+	steps, err := parseFilterSteps(path, true)
+	if err != nil {
+		return nil, err
+	}
+	pred.path = steps
+	return pred, nil
+}
+
+// parseFilterLiteral parses a literal value in a filter:
+// a JSON value or a string in single quotes.
+func parseFilterLiteral(s string) (interface{}, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], `\'`, `'`, -1), nil
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// apply applies the filter to the given JSON document
+// and returns the selected values.
+func (f *jsonFilter) apply(data []byte) ([]json.RawMessage, error) {
+	return applyFilterSteps(f.steps, []json.RawMessage{data})
+}
+
+// applyFilterSteps applies the given steps to each of vals.
+func applyFilterSteps(steps []filterStep, vals []json.RawMessage) ([]json.RawMessage, error) {
+	for _, step := range steps {
+		var next []json.RawMessage
+		for _, v := range vals {
+			results, err := step.apply(v)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, results...)
+		}
+		vals = next
+	}
+	return vals, nil
+}
+
+var jsonNull = json.RawMessage("null")
+
+// apply applies a single step to the value v. As in jq, selecting
+// a field or index that isn't present, or selecting from null,
+// results in null. Iterating over null results in no values.
+func (step *filterStep) apply(v json.RawMessage) ([]json.RawMessage, error) {
+	kind := rawKind(v)
+	if kind == 'n' {
+		if step.kind == stepField || step.kind == stepIndex {
+			return []json.RawMessage{jsonNull}, nil
+		}
+		return nil, nil
+	}
+	if step.kind == stepField && kind == '[' && isAllDigits(step.field) {
+		// A field such as .0 selects an array element.
+		index, err := strconv.Atoi(step.field)
+		if err != nil {
+			return nil, fmt.Errorf("array index %s out of range", step.field)
+		}
+		step = &filterStep{kind: stepIndex, index: index}
+	}
+	switch step.kind {
+	case stepField:
+		if kind != '{' {
+			return nil, fmt.Errorf("cannot select field %q from %s", step.field, rawKindName(kind))
+		}
+		members, err := objectMembers(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if m.key == step.field {
+				return []json.RawMessage{m.val}, nil
+			}
+		}
+		return []json.RawMessage{jsonNull}, nil
+	case stepIndex:
+		if kind != '[' {
+			return nil, fmt.Errorf("cannot select index %d from %s", step.index, rawKindName(kind))
+		}
+		elems, err := arrayElems(v)
+		if err != nil {
+			return nil, err
+		}
+		i := step.index
+		if i < 0 {
+			i += len(elems)
+		}
+		if i < 0 || i >= len(elems) {
+			return []json.RawMessage{jsonNull}, nil
+		}
+		return elems[i : i+1], nil
+	case stepSlice:
+		if kind != '[' {
+			return nil, fmt.Errorf("cannot slice %s", rawKindName(kind))
+		}
+		elems, err := arrayElems(v)
+		if err != nil {
+			return nil, err
+		}
+		start, end := sliceBound(step.start, 0, len(elems)), sliceBound(step.end, len(elems), len(elems))
+		if start >= end {
+			return nil, nil
+		}
+		return elems[start:end], nil
+	}
+	// Wildcards and predicates select from all elements
+	// of an array or all values of an object.
+	var elems []json.RawMessage
+	switch kind {
+	case '[':
+		var err error
+		elems, err = arrayElems(v)
+		if err != nil {
+			return nil, err
+		}
+	case '{':
+		members, err := objectMembers(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			elems = append(elems, m.val)
+		}
+	default:
+		return nil, fmt.Errorf("cannot iterate over %s", rawKindName(kind))
+	}
+	if step.kind == stepWildcard {
+		return elems, nil
+	}
+	var results []json.RawMessage
+	for _, elem := range elems {
+		ok, err := step.pred.match(elem)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			results = append(results, elem)
+		}
+	}
+	return results, nil
+}
+
+// sliceBound returns the slice index for the given bound,
+// which is def if the bound is omitted. Negative bounds
+// count from the end, and bounds are clamped to the
+// array length n.
+func sliceBound(bound *int, def, n int) int {
+	if bound == nil {
+		return def
+	}
+	i := *bound
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// match reports whether the predicate holds for v.
+func (pred *filterPredicate) match(v json.RawMessage) (bool, error) {
+	vals, err := applyFilterSteps(pred.path, []json.RawMessage{v})
+	if err != nil {
+		// Elements that don't have the right shape
+		// don't match, rather than being an error.
+		return false, nil
+	}
+	var x interface{}
+	dec := json.NewDecoder(bytes.NewReader(vals[0]))
+	dec.UseNumber()
+	if err := dec.Decode(&x); err != nil {
+		return false, err
+	}
+	if pred.op == "" {
+		return x != nil && x != false, nil
+	}
+	if pred.op == "==" || pred.op == "!=" {
+		return filterEqual(x, pred.value) == (pred.op == "=="), nil
+	}
+	c, ok := filterCompare(x, pred.value)
+	if !ok {
+		return false, nil
+	}
+	switch pred.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+// filterEqual reports whether the JSON values x and y,
+// decoded with json.Number for numbers, are equal.
+func filterEqual(x, y interface{}) bool {
+	if c, ok := filterCompare(x, y); ok {
+		return c == 0
+	}
+	xdata, _ := json.Marshal(x)
+	ydata, _ := json.Marshal(y)
+	return bytes.Equal(xdata, ydata)
+}
+
+// filterCompare compares x and y, which must both be
+// numbers or both be strings, returning -1, 0 or 1.
+func filterCompare(x, y interface{}) (int, bool) {
+	switch x := x.(type) {
+	case json.Number:
+		y, ok := y.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		xf, err1 := x.Float64()
+		yf, err2 := y.Float64()
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		switch {
+		case xf < yf:
+			return -1, true
+		case xf > yf:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := y.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+// objectMember holds a member of a JSON object.
+type objectMember struct {
+	key string
+	val json.RawMessage
+}
+
+// objectMembers returns the members of the given
+// JSON object in the order they appear.
+func objectMembers(v json.RawMessage) ([]objectMember, error) {
+	dec := json.NewDecoder(bytes.NewReader(v))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var members []objectMember
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return nil, err
+		}
+		members = append(members, objectMember{key, val})
+	}
+	return members, nil
+}
+
+// arrayElems returns the elements of the given JSON array.
+func arrayElems(v json.RawMessage) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(v))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var elems []json.RawMessage
+	for dec.More() {
+		var elem json.RawMessage
+		if err := dec.Decode(&elem); err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// rawKind returns the first character of the JSON value v, which
+// identifies its kind: '{', '[', '"', 't' or 'f', 'n', or a
+// digit or '-' for a number.
+func rawKind(v json.RawMessage) byte {
+	v = bytes.TrimLeft(v, " \t\r\n")
+	if len(v) == 0 {
+		return 0
+	}
+	return v[0]
+}
+
+// rawKindName returns a description of the
+// kind of JSON value returned by rawKind.
+func rawKindName(kind byte) string {
+	switch kind {
+	case '{':
+		return "an object"
+	case '[':
+		return "an array"
+	case '"':
+		return "a string"
+	case 't', 'f':
+		return "a boolean"
+	case 'n':
+		return "null"
+	}
+	return "a number"
+}
+
+// writeFilteredBody applies the --filter expression to the JSON
+// body and writes each selected value to w on its own line,
+// formatted as specified by p. With --filter-raw, strings
+// are written without quotes. It returns an error if
+// the body isn't valid JSON. Any warnings are written
+// to stderr.
+func writeFilteredBody(w io.Writer, body io.Reader, p *params, pal *palette, stderr io.Writer) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %v", err)
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("cannot apply filter: response body is not valid JSON")
+	}
+	vals, err := p.filter.apply(data)
+	if err != nil {
+		return fmt.Errorf("cannot apply filter: %v", err)
+	}
+	for _, v := range vals {
+		if p.filterRaw && rawKind(v) == '"' {
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\n", s)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, v); err != nil {
+			return err
+		}
+		if err := writeFormattedJSON(w, buf.Bytes(), p, pal, stderr); err != nil {
+			return err
+		}
+		if !p.format {
+			fmt.Fprintf(w, "\n")
+		}
+	}
+	return nil
+}
@@ -1,28 +1,42 @@
 module github.com/rogpeppe/bhttp
 
-go 1.13
+go 1.17
+
+require (
+	github.com/andybalholm/brotli v1.0.0
+	github.com/juju/gnuflag v0.0.0-20160809165214-4e76c5658185
+	github.com/juju/loggo v0.0.0-20170605014607-8232ab8918d9
+	github.com/juju/persistent-cookiejar v0.0.0-20170428161559-d67418f14c93
+	github.com/juju/testing v0.0.0-20170608054451-2fe0e88cf232
+	github.com/klauspost/compress v1.10.3
+	github.com/rogpeppe/rjson v0.0.0-20151026200957-77220b71d327
+	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d
+	golang.org/x/crypto v0.0.0-20180723164146-c126467f60eb
+	golang.org/x/net v0.0.0-20171004034648-a04bdaca5b32
+	golang.org/x/term v0.10.0
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127
+	gopkg.in/errgo.v1 v1.0.1
+	gopkg.in/macaroon-bakery.v2 v2.1.0
+	gopkg.in/macaroon.v2 v2.1.0
+)
 
 require (
 	github.com/frankban/quicktest v1.4.0 // indirect
 	github.com/golang/protobuf v1.3.1 // indirect
+	github.com/google/go-cmp v0.3.0 // indirect
 	github.com/juju/errors v0.0.0-20150916125642-1b5e39b83d18 // indirect
-	github.com/juju/gnuflag v0.0.0-20160809165214-4e76c5658185
 	github.com/juju/go4 v0.0.0-20160222163258-40d72ab9641a // indirect
-	github.com/juju/loggo v0.0.0-20170605014607-8232ab8918d9
-	github.com/juju/persistent-cookiejar v0.0.0-20170428161559-d67418f14c93
 	github.com/juju/retry v0.0.0-20151029024821-62c620325291 // indirect
-	github.com/juju/testing v0.0.0-20170608054451-2fe0e88cf232
 	github.com/juju/utils v0.0.0-20171122093653-4d9b38694f1e // indirect
 	github.com/juju/version v0.0.0-20161031051906-1f41e27e54f2 // indirect
 	github.com/juju/webbrowser v0.0.0-20180907093207-efb9432b2bcb // indirect
+	github.com/julienschmidt/httprouter v1.2.0 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/text v0.1.0 // indirect
 	github.com/rogpeppe/fastuuid v1.1.0 // indirect
-	github.com/rogpeppe/rjson v0.0.0-20151026200957-77220b71d327
-	golang.org/x/net v0.0.0-20171004034648-a04bdaca5b32
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127
-	gopkg.in/errgo.v1 v1.0.1
+	golang.org/x/sys v0.10.0 // indirect
 	gopkg.in/httprequest.v1 v1.2.0 // indirect
-	gopkg.in/macaroon-bakery.v2 v2.1.0
-	gopkg.in/macaroon.v2 v2.1.0 // indirect
+	gopkg.in/mgo.v2 v2.0.0-20180705113604-9856a29383ce // indirect
 	gopkg.in/retry.v1 v1.0.3 // indirect
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	flag "github.com/juju/gnuflag"
+	"github.com/juju/persistent-cookiejar"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon.v2"
+)
+
+const macaroonsHelpMessage = `usage: http macaroons [flag...]
+
+Decode and print the macaroons held in macaroon-* cookies
+in the persistent cookie jar, including their caveats and
+the time that each set of macaroons expires.
+
+`
+
+// macaroonsCmd implements the macaroons subcommand.
+func macaroonsCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("macaroons", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+	var (
+		cookieFile string
+		filter     cookieFilter
+	)
+	fset.StringVar(&cookieFile, "cookiefile", cookiejar.DefaultCookieFile(), "file that persistent cookies are stored in")
+	fset.StringVar(&filter.domain, "domain", "", "show only macaroons for the given domain and its subdomains")
+	fset.StringVar(&filter.path, "path", "", "show only macaroons whose cookie path starts with the given path")
+	fset.Usage = func() {
+		fmt.Fprint(stderr, macaroonsHelpMessage)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(true, args); err != nil {
+		return &exitError{2}
+	}
+	if len(fset.Args()) > 0 {
+		fset.Usage()
+		return &exitError{2}
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{
+		Filename: cookieFile,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot open cookie jar: %v", err)
+	}
+	first := true
+	for _, c := range filter.cookies(jar, nil) {
+		if !strings.HasPrefix(c.Name, "macaroon-") {
+			continue
+		}
+		if !first {
+			fmt.Fprintf(stdout, "\n")
+		}
+		first = false
+		printMacaroonCookie(stdout, c, time.Now())
+	}
+	return nil
+}
+
+// printMacaroonCookie prints the macaroons held in
+// the given cookie. The current time is used to
+// determine whether the macaroons have expired.
+func printMacaroonCookie(w io.Writer, c *http.Cookie, now time.Time) {
+	fmt.Fprintf(w, "%s (domain %s, path %s)\n", c.Name, c.Domain, c.Path)
+	ms, err := decodeMacaroons(c.Value)
+	if err != nil {
+		fmt.Fprintf(w, "\terror: %v\n", err)
+		return
+	}
+	if t, ok := checkers.MacaroonsExpiryTime(checkers.New(nil).Namespace(), ms); ok {
+		expired := ""
+		if !t.After(now) {
+			expired = " (expired)"
+		}
+		fmt.Fprintf(w, "\texpires: %s%s\n", t.UTC().Format(time.RFC3339), expired)
+	} else {
+		fmt.Fprintf(w, "\texpires: never\n")
+	}
+	for i, m := range ms {
+		kind := "macaroon"
+		if i > 0 {
+			kind = "discharge"
+		}
+		fmt.Fprintf(w, "\t%s\n", kind)
+		fmt.Fprintf(w, "\t\tlocation: %q\n", m.Location())
+		fmt.Fprintf(w, "\t\tidentifier: %s\n", formatMacaroonId(m.Id()))
+		for _, cav := range m.Caveats() {
+			if len(cav.VerificationId) == 0 {
+				fmt.Fprintf(w, "\t\tcaveat: %s\n", cav.Id)
+			} else {
+				fmt.Fprintf(w, "\t\tthird-party caveat: %q\n", cav.Location)
+			}
+		}
+	}
+}
+
+// decodeMacaroons decodes the value of a macaroon cookie, which holds
+// a base64-encoded macaroon slice in either JSON or binary format.
+func decodeMacaroons(value string) (macaroon.Slice, error) {
+	data, err := macaroon.Base64Decode([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("cannot base64-decode macaroons: %v", err)
+	}
+	var ms macaroon.Slice
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &ms)
+	} else {
+		err = ms.UnmarshalBinary(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal macaroons: %v", err)
+	}
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("no macaroons found")
+	}
+	return ms, nil
+}
+
+// formatMacaroonId returns a printable form of a macaroon identifier.
+// Identifiers are often binary, in which case they're
+// printed in base64.
+func formatMacaroonId(id []byte) string {
+	if utf8.Valid(id) && strings.IndexFunc(string(id), func(r rune) bool {
+		return !unicode.IsPrint(r)
+	}) == -1 {
+		return fmt.Sprintf("%q", id)
+	}
+	return "base64 " + base64.RawURLEncoding.EncodeToString(id)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// formFile holds a file to be sent as part of a
+// multipart form.
+type formFile struct {
+	// field holds the name of the form field.
+	field string
+
+	// path holds the name of the file to send.
+	path string
+
+	// contentType holds the content type of the
+	// file. If it's empty, it will be deduced from
+	// the file name or contents.
+	contentType string
+}
+
+// multipartBody is a request body that holds a multipart form.
+// It reads file contents only as the body is read, so
+// arbitrarily large files can be sent without holding them
+// in memory. It is seekable so that the request can be
+// retried after a macaroon has been discharged.
+type multipartBody struct {
+	*io.SectionReader
+	files []*os.File
+}
+
+// Close implements io.Closer by closing all the files
+// in the body.
+func (b *multipartBody) Close() error {
+	var firstErr error
+	for _, f := range b.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// newMultipartBody returns a body holding the given form values
+// and files encoded as multipart/form-data, and the content type
+// that should be used to send it.
+func newMultipartBody(form url.Values, files []formFile) (_ *multipartBody, contentType string, err error) {
+	body := &multipartBody{}
+	defer func() {
+		if err != nil {
+			body.Close()
+		}
+	}()
+	var parts multiReaderAt
+	var buf bytes.Buffer
+	flush := func() {
+		data := append([]byte(nil), buf.Bytes()...)
+		parts.add(bytes.NewReader(data), int64(len(data)))
+		buf.Reset()
+	}
+	w := multipart.NewWriter(&buf)
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, val := range form[key] {
+			if err := w.WriteField(key, val); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	for _, ff := range files {
+		f, err := os.Open(ff.path)
+		if err != nil {
+			return nil, "", err
+		}
+		body.files = append(body.files, f)
+		info, err := f.Stat()
+		if err != nil {
+			return nil, "", err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, "", fmt.Errorf("%s is not a regular file", ff.path)
+		}
+		ctype := ff.contentType
+		if ctype == "" {
+			ctype, err = fileContentType(f, ff.path)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(ff.field), quoteEscaper.Replace(filepath.Base(ff.path))))
+		h.Set("Content-Type", ctype)
+		if _, err := w.CreatePart(h); err != nil {
+			return nil, "", err
+		}
+		flush()
+		parts.add(f, info.Size())
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	flush()
+	body.SectionReader = io.NewSectionReader(&parts, 0, parts.size)
+	return body, w.FormDataContentType(), nil
+}
+
+// fileContentType returns the content type of the given file,
+// inferred from its extension if possible, or from its initial
+// contents otherwise.
+func fileContentType(f *os.File, path string) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(path)); ctype != "" {
+		return ctype, nil
+	}
+	buf := make([]byte, 512)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// multiReaderAt is an io.ReaderAt that's the logical
+// concatenation of a sequence of io.ReaderAt values.
+type multiReaderAt struct {
+	parts []readerAtPart
+	size  int64
+}
+
+type readerAtPart struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// add adds a reader holding the given number of bytes
+// to the end of m.
+func (m *multiReaderAt) add(r io.ReaderAt, size int64) {
+	m.parts = append(m.parts, readerAtPart{r, size})
+	m.size += size
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *multiReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	total := 0
+	for _, part := range m.parts {
+		if len(buf) == 0 {
+			break
+		}
+		if off >= part.size {
+			off -= part.size
+			continue
+		}
+		want := buf
+		if remain := part.size - off; int64(len(want)) > remain {
+			want = want[:remain]
+		}
+		n, err := part.r.ReadAt(want, off)
+		total += n
+		if n < len(want) {
+			if err == nil || err == io.EOF {
+				// The part is shorter than it claimed to be
+				// (for example a file has been truncated).
+				err = io.ErrUnexpectedEOF
+			}
+			return total, err
+		}
+		buf = buf[n:]
+		off = 0
+	}
+	if len(buf) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// maxNestedIndex holds the largest array index allowed
+// in a nested data field key. It guards against
+// accidentally creating enormous arrays.
+const maxNestedIndex = 100000
+
+// keyElem holds one element of a nested data field key.
+// For example, the key "items[0][id]" has the elements
+// "items", 0 and "id".
+type keyElem struct {
+	// field holds the object field name, when index is -1.
+	field string
+
+	// index holds the array index. It's -1 when
+	// the element names an object field, and
+	// appendIndex when it's "[]".
+	index int
+}
+
+const appendIndex = -2
+
+func (e keyElem) String() string {
+	switch e.index {
+	case -1:
+		return "[" + e.field + "]"
+	case appendIndex:
+		return "[]"
+	}
+	return "[" + strconv.Itoa(e.index) + "]"
+}
+
+// parseNestedKey parses a data field key that may
+// refer to values inside nested objects and arrays,
+// in the style of HTTPie. For example:
+//
+//	user[name]
+//	user[tags][]
+//	items[0][id]
+//
+// An element holding only digits is an array index
+// and an empty element appends to an array. The key is
+// as written on the command line: a backslash escapes the
+// following character, so that, for example, a\[b\] is
+// the single field "a[b]".
+func parseNestedKey(key string) ([]keyElem, error) {
+	var elems []keyElem
+	var name []byte
+	inBracket, afterBracket := false, false
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case afterBracket && c != '[':
+			return nil, fmt.Errorf("invalid key %q: unexpected text %q after ']'", key, key[i:])
+		case c == '\\' && i+1 < len(key):
+			i++
+			name = append(name, key[i])
+		case c == '[' && !inBracket:
+			if len(elems) == 0 {
+				if len(name) == 0 {
+					return nil, fmt.Errorf("invalid key %q: key must start with a field name", key)
+				}
+				elems = append(elems, keyElem{field: string(name), index: -1})
+			}
+			name, inBracket, afterBracket = name[:0], true, false
+		case c == ']' && inBracket:
+			e, err := bracketElem(string(name))
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %q: %v", key, err)
+			}
+			elems = append(elems, e)
+			name, inBracket, afterBracket = name[:0], false, true
+		default:
+			name = append(name, c)
+		}
+	}
+	if inBracket {
+		return nil, fmt.Errorf("invalid key %q: missing ']'", key)
+	}
+	if len(elems) == 0 {
+		return []keyElem{{field: string(name), index: -1}}, nil
+	}
+	return elems, nil
+}
+
+// bracketElem returns the key element for
+// the given text found inside brackets.
+func bracketElem(name string) (keyElem, error) {
+	switch {
+	case name == "":
+		return keyElem{index: appendIndex}, nil
+	case isAllDigits(name):
+		n, err := strconv.Atoi(name)
+		if err != nil || n > maxNestedIndex {
+			return keyElem{}, fmt.Errorf("array index %s out of range", name)
+		}
+		return keyElem{index: n}, nil
+	}
+	return keyElem{field: name, index: -1}, nil
+}
+
+// setJSON sets the value at the given (possibly nested) key
+// in req.jsonObj, creating intermediate objects and
+// arrays as needed. The key is as written on the command
+// line, with backslash escapes in place (see parseNestedKey).
+func (req *request) setJSON(key string, val interface{}) error {
+	elems, err := parseNestedKey(key)
+	if err != nil {
+		return err
+	}
+	field := elems[0].field
+	v, err := setNested(req.jsonObj[field], elems[1:], val, field)
+	if err != nil {
+		return fmt.Errorf("cannot set %q: %v", key, err)
+	}
+	req.jsonObj[field] = v
+	return nil
+}
+
+// setNested sets the value at the given path within old,
+// which is found at the path described by prefix, and returns
+// the updated value.
+func setNested(old interface{}, elems []keyElem, val interface{}, prefix string) (interface{}, error) {
+	if len(elems) == 0 {
+		switch old.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s already holds %s", prefix, jsonKind(old))
+		}
+		return val, nil
+	}
+	e := elems[0]
+	path := prefix + e.String()
+	if e.index == -1 {
+		if old == nil {
+			old = make(map[string]interface{})
+		}
+		obj, ok := old.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is %s, not an object", prefix, jsonKind(old))
+		}
+		v, err := setNested(obj[e.field], elems[1:], val, path)
+		if err != nil {
+			return nil, err
+		}
+		obj[e.field] = v
+		return obj, nil
+	}
+	if old == nil {
+		old = []interface{}{}
+	}
+	arr, ok := old.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is %s, not an array", prefix, jsonKind(old))
+	}
+	index := e.index
+	if index == appendIndex {
+		index = len(arr)
+	}
+	for len(arr) <= index {
+		arr = append(arr, nil)
+	}
+	v, err := setNested(arr[index], elems[1:], val, path)
+	if err != nil {
+		return nil, err
+	}
+	arr[index] = v
+	return arr, nil
+}
+
+// jsonKind returns a description of the kind of
+// JSON value held in v.
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	}
+	return "a JSON value"
+}
+
+func isAllDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OAuth 2.0 grant types as specified with --oauth2-grant.
+const (
+	grantClientCredentials = "client-credentials"
+	grantRefreshToken      = "refresh-token"
+	grantDeviceCode        = "device-code"
+)
+
+// oauth2Auth implements authProvider by obtaining OAuth 2.0
+// access tokens from a token endpoint and using them to
+// authenticate requests.
+type oauth2Auth struct {
+	// grant holds the grant used to obtain a new token
+	// when there's no cached token that can be refreshed.
+	grant string
+
+	tokenURL     string
+	deviceURL    string
+	clientID     string
+	clientSecret string
+	scope        string
+	refreshToken string
+
+	// cacheFile holds the file that tokens are cached in.
+	cacheFile string
+
+	// loginJSON specifies that device-code login
+	// instructions should be printed as JSON.
+	loginJSON bool
+
+	// stderr is used to print device-code login instructions.
+	stderr io.Writer
+
+	// tok holds the token in use, and changed records
+	// whether it has been obtained since the cache was read.
+	tok     *oauth2Token
+	changed bool
+}
+
+// oauth2Token holds a token as stored in the token cache.
+type oauth2Token struct {
+	AccessToken  string    `json:"accessToken"`
+	TokenType    string    `json:"tokenType,omitempty"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// valid reports whether the token can be used at the given time.
+// Tokens are treated as expired a little early to allow
+// for the time taken to send the request.
+func (t *oauth2Token) valid(now time.Time) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || now.Add(10*time.Second).Before(t.Expiry))
+}
+
+// newOAuth2Auth returns the OAuth 2.0 authenticator
+// specified by p. The --auth flag holds the client
+// credentials as CLIENT_ID[:CLIENT_SECRET].
+func newOAuth2Auth(p *params) (authProvider, error) {
+	a := &oauth2Auth{
+		grant:        p.oauth2Grant,
+		tokenURL:     p.oauth2TokenURL,
+		deviceURL:    p.oauth2DeviceURL,
+		scope:        p.oauth2Scope,
+		refreshToken: p.oauth2RefreshToken,
+		cacheFile:    defaultOAuth2CacheFile(),
+		loginJSON:    p.loginJSON,
+		stderr:       os.Stderr,
+	}
+	if a.tokenURL == "" {
+		return nil, fmt.Errorf("no OAuth 2.0 token endpoint specified (use --oauth2-token-url)")
+	}
+	if i := strings.Index(p.auth, ":"); i >= 0 {
+		a.clientID, a.clientSecret = p.auth[:i], p.auth[i+1:]
+	} else {
+		a.clientID = p.auth
+	}
+	switch a.grant {
+	case grantClientCredentials:
+		if a.clientID == "" {
+			return nil, fmt.Errorf("the client-credentials grant requires --auth=CLIENT_ID:CLIENT_SECRET")
+		}
+	case grantRefreshToken:
+		if a.refreshToken == "" {
+			return nil, fmt.Errorf("the refresh-token grant requires --oauth2-refresh-token")
+		}
+	case grantDeviceCode:
+		if a.deviceURL == "" {
+			return nil, fmt.Errorf("the device-code grant requires --oauth2-device-url")
+		}
+		if a.clientID == "" {
+			return nil, fmt.Errorf("the device-code grant requires --auth=CLIENT_ID")
+		}
+	default:
+		return nil, fmt.Errorf("invalid --oauth2-grant value %q (must be one of client-credentials, refresh-token or device-code)", a.grant)
+	}
+	return a, nil
+}
+
+// defaultOAuth2CacheFile returns the file that
+// OAuth 2.0 tokens are cached in.
+func defaultOAuth2CacheFile() string {
+	return filepath.Join(homeDir(), ".bhttp", "oauth2-tokens.json")
+}
+
+// cacheKey returns the key that tokens for this authenticator
+// are stored under in the token cache. Tokens are cached
+// per issuer, identified by the token endpoint, and
+// per client and scope.
+func (a *oauth2Auth) cacheKey() string {
+	return a.tokenURL + " " + a.clientID + " " + a.scope
+}
+
+// prepare implements authProvider.prepare by adding an access token
+// to the request, using a cached token if possible. When the request
+// is not going to be sent, only a cached token is used.
+func (a *oauth2Auth) prepare(ctx context.Context, client *http.Client, req *http.Request) error {
+	if a.tok == nil {
+		a.tok = a.cachedToken()
+	}
+	if !a.tok.valid(time.Now()) {
+		if client == nil {
+			return nil
+		}
+		if err := a.newToken(ctx, client); err != nil {
+			return err
+		}
+	}
+	tokenType := a.tok.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+a.tok.AccessToken)
+	return nil
+}
+
+// challenge implements authProvider.challenge. If the token that
+// was rejected came from the cache, a new one is obtained so
+// that the request can be tried again.
+func (a *oauth2Auth) challenge(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response) (bool, error) {
+	if a.changed {
+		return false, nil
+	}
+	if err := a.newToken(ctx, client); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// save implements authProvider.save by storing any
+// newly obtained token in the token cache.
+func (a *oauth2Auth) save(resp *http.Response) error {
+	if !a.changed {
+		return nil
+	}
+	if err := a.saveToken(a.tok); err != nil {
+		return fmt.Errorf("cannot cache OAuth 2.0 token: %v", err)
+	}
+	return nil
+}
+
+// newToken obtains a new token to replace a.tok. If the old
+// token has a refresh token, it is used first; otherwise,
+// or if that fails, the configured grant is used.
+func (a *oauth2Auth) newToken(ctx context.Context, client *http.Client) error {
+	old := a.tok
+	var tok *oauth2Token
+	if old != nil && old.RefreshToken != "" {
+		tok, _ = a.refresh(ctx, client, old.RefreshToken)
+	}
+	if tok == nil {
+		var err error
+		switch a.grant {
+		case grantClientCredentials:
+			tok, err = a.tokenRequest(ctx, client, url.Values{
+				"grant_type": {"client_credentials"},
+			})
+		case grantRefreshToken:
+			tok, err = a.refresh(ctx, client, a.refreshToken)
+		case grantDeviceCode:
+			tok, err = a.deviceCodeToken(ctx, client)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if tok.RefreshToken == "" && old != nil {
+		// The server may not issue a new refresh token when
+		// refreshing, in which case the old one remains valid.
+		tok.RefreshToken = old.RefreshToken
+	}
+	a.tok, a.changed = tok, true
+	return nil
+}
+
+// refresh obtains a new token using the given refresh token.
+func (a *oauth2Auth) refresh(ctx context.Context, client *http.Client, refreshToken string) (*oauth2Token, error) {
+	return a.tokenRequest(ctx, client, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// oauth2Error holds an error response from an OAuth 2.0 endpoint.
+type oauth2Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauth2Error) Error() string {
+	if e.Description == "" {
+		return "OAuth 2.0 error " + e.Code
+	}
+	return fmt.Sprintf("OAuth 2.0 error %s: %s", e.Code, e.Description)
+}
+
+// tokenResponse holds a successful response from a token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// tokenRequest sends a token request with the given parameters
+// to the token endpoint and returns the resulting token.
+func (a *oauth2Auth) tokenRequest(ctx context.Context, client *http.Client, form url.Values) (*oauth2Token, error) {
+	if a.scope != "" {
+		form.Set("scope", a.scope)
+	}
+	var resp tokenResponse
+	if err := a.post(ctx, client, a.tokenURL, form, &resp); err != nil {
+		return nil, fmt.Errorf("cannot get OAuth 2.0 token: %w", err)
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("cannot get OAuth 2.0 token: no access token in response")
+	}
+	tok := &oauth2Token{
+		AccessToken:  resp.AccessToken,
+		TokenType:    resp.TokenType,
+		RefreshToken: resp.RefreshToken,
+	}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// post posts the given form to the given OAuth 2.0 endpoint,
+// authenticating as the client, and unmarshals the JSON
+// response into v. An OAuth 2.0 error response is
+// returned as an *oauth2Error.
+func (a *oauth2Auth) post(ctx context.Context, client *http.Client, endpoint string, form url.Values, v interface{}) error {
+	if a.clientSecret == "" && a.clientID != "" {
+		form.Set("client_id", a.clientID)
+	}
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMemoryBody))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oerr oauth2Error
+		if json.Unmarshal(data, &oerr) == nil && oerr.Code != "" {
+			return &oerr
+		}
+		return fmt.Errorf("unexpected response from %s: %s", endpoint, resp.Status)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("cannot unmarshal response from %s: %v", endpoint, err)
+	}
+	return nil
+}
+
+// deviceAuthResponse holds the response from a
+// device authorization endpoint (RFC 8628).
+type deviceAuthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// deviceCodeToken obtains a token with the device authorization
+// grant. It prints instructions for the user to log in and polls
+// the token endpoint until the login has completed.
+func (a *oauth2Auth) deviceCodeToken(ctx context.Context, client *http.Client) (*oauth2Token, error) {
+	form := url.Values{}
+	if a.scope != "" {
+		form.Set("scope", a.scope)
+	}
+	var auth deviceAuthResponse
+	if err := a.post(ctx, client, a.deviceURL, form, &auth); err != nil {
+		return nil, fmt.Errorf("cannot start device authorization: %w", err)
+	}
+	if err := a.showDeviceLogin(&auth); err != nil {
+		return nil, err
+	}
+	interval := 5 * time.Second
+	if auth.Interval > 0 {
+		interval = time.Duration(auth.Interval) * time.Second
+	}
+	var deadline time.Time
+	if auth.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	}
+	for {
+		if err := oauth2Wait(ctx, interval); err != nil {
+			return nil, err
+		}
+		tok, err := a.tokenRequest(ctx, client, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {auth.DeviceCode},
+		})
+		var oerr *oauth2Error
+		if err == nil || !errors.As(err, &oerr) {
+			return tok, err
+		}
+		switch oerr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, fmt.Errorf("device authorization expired before login completed")
+		}
+	}
+}
+
+// showDeviceLogin prints the instructions for the user to complete
+// a device authorization login, as JSON if --login-json is specified.
+func (a *oauth2Auth) showDeviceLogin(auth *deviceAuthResponse) error {
+	if a.loginJSON {
+		u := auth.VerificationURIComplete
+		if u == "" {
+			u = auth.VerificationURI
+		}
+		data, err := json.Marshal(loginEvent{
+			Event: "device-code",
+			URL:   u,
+			Code:  auth.UserCode,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.stderr, "%s\n", data)
+		return nil
+	}
+	fmt.Fprintf(a.stderr, "Please visit this URL to log in:\n%s\nand enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	if auth.VerificationURIComplete != "" {
+		fmt.Fprintf(a.stderr, "or visit this URL:\n%s\n", auth.VerificationURIComplete)
+	}
+	fmt.Fprintf(a.stderr, "Waiting for the login to complete...\n")
+	return nil
+}
+
+// oauth2Wait waits for the given duration before the token endpoint
+// is polled again during a device authorization login.
+// It's a variable so that it can be replaced in tests.
+var oauth2Wait = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readTokenCache reads the token cache. A missing
+// cache file is treated as an empty cache.
+func (a *oauth2Auth) readTokenCache() (map[string]*oauth2Token, error) {
+	cache := make(map[string]*oauth2Token)
+	data, err := ioutil.ReadFile(a.cacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", a.cacheFile, err)
+	}
+	return cache, nil
+}
+
+// cachedToken returns the cached token for this
+// authenticator, or nil if there is none.
+func (a *oauth2Auth) cachedToken() *oauth2Token {
+	cache, err := a.readTokenCache()
+	if err != nil {
+		// An unreadable cache shouldn't stop us getting
+		// a new token; it'll be overwritten when saved.
+		return nil
+	}
+	return cache[a.cacheKey()]
+}
+
+// saveToken stores the given token in the token cache.
+// The cache holds credentials, so it is only readable
+// by the current user.
+func (a *oauth2Auth) saveToken(tok *oauth2Token) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	cache, err := a.readTokenCache()
+	if err != nil {
+		cache = make(map[string]*oauth2Token)
+	}
+	cache[a.cacheKey()] = tok
+	data, err := json.MarshalIndent(cache, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(a.cacheFile, append(data, '\n'))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// authPluginPrefix is prepended to the --auth-type value to
+// find the executable that implements an authentication plugin.
+const authPluginPrefix = "bhttp-auth-"
+
+// pluginAuth implements authProvider by running an external
+// executable, in the style of git credential helpers. The
+// executable is run with the name of the authProvider method as
+// its argument ("store" for save), reads attributes describing the
+// request and response from its standard input and writes
+// attributes to its standard output. See helpMessage for
+// details of the protocol.
+type pluginAuth struct {
+	path string
+	auth string
+}
+
+// pluginAttr holds a key=value attribute
+// exchanged with an authentication plugin.
+type pluginAttr struct {
+	key string
+	val string
+}
+
+// newPluginAuth returns a provider that runs the
+// authentication plugin named by --auth-type.
+func newPluginAuth(p *params) (authProvider, error) {
+	path, err := exec.LookPath(authPluginPrefix + p.authType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --auth-type value %q (must be one of %s, or the name of a plugin found as %s%s in $PATH)", p.authType, authTypeNames(), authPluginPrefix, p.authType)
+	}
+	return &pluginAuth{
+		path: path,
+		auth: p.auth,
+	}, nil
+}
+
+// prepare implements authProvider.prepare by setting any
+// headers returned by the plugin.
+func (a *pluginAuth) prepare(ctx context.Context, client *http.Client, req *http.Request) error {
+	out, err := a.run(ctx, "prepare", a.requestAttrs(req), pluginStderr(req))
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	for _, attr := range out {
+		if attr.key != "header" {
+			continue
+		}
+		i := strings.Index(attr.val, ":")
+		if i <= 0 {
+			return fmt.Errorf("auth plugin %s returned invalid header %q", a.name(), attr.val)
+		}
+		name, val := http.CanonicalHeaderKey(strings.TrimSpace(attr.val[:i])), strings.TrimSpace(attr.val[i+1:])
+		if set[name] {
+			req.Header.Add(name, val)
+		} else {
+			req.Header.Set(name, val)
+			set[name] = true
+		}
+	}
+	return nil
+}
+
+// challenge implements authProvider.challenge. The request
+// is sent again if the plugin returns retry=true.
+func (a *pluginAuth) challenge(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response) (bool, error) {
+	out, err := a.run(ctx, "challenge", append(a.requestAttrs(req), responseAttrs(resp)...), pluginStderr(req))
+	if err != nil {
+		return false, err
+	}
+	for _, attr := range out {
+		if attr.key == "retry" && attr.val == "true" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// save implements authProvider.save by running
+// the plugin with the "store" argument.
+func (a *pluginAuth) save(resp *http.Response) error {
+	var attrs []pluginAttr
+	stderr := io.Writer(os.Stderr)
+	if resp.Request != nil {
+		attrs = a.requestAttrs(resp.Request)
+		stderr = pluginStderr(resp.Request)
+	}
+	_, err := a.run(context.Background(), "store", append(attrs, responseAttrs(resp)...), stderr)
+	return err
+}
+
+func (a *pluginAuth) name() string {
+	return filepath.Base(a.path)
+}
+
+// requestAttrs returns the attributes describing the given request.
+func (a *pluginAuth) requestAttrs(req *http.Request) []pluginAttr {
+	attrs := []pluginAttr{
+		{"method", req.Method},
+		{"url", req.URL.String()},
+	}
+	if a.auth != "" {
+		attrs = append(attrs, pluginAttr{"auth", a.auth})
+	}
+	return append(attrs, headerAttrs("header", req.Header)...)
+}
+
+// responseAttrs returns the attributes describing the given response.
+func responseAttrs(resp *http.Response) []pluginAttr {
+	attrs := []pluginAttr{
+		{"status", strconv.Itoa(resp.StatusCode)},
+	}
+	return append(attrs, headerAttrs("response-header", resp.Header)...)
+}
+
+// privateHeaders holds the headers that are not passed
+// to plugins because they may hold credentials, such as
+// macaroons or the credentials of another provider.
+var privateHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"Set-Cookie":          true,
+}
+
+// headerAttrs returns an attribute with the given key
+// for each header value, sorted by header name.
+// Headers in privateHeaders are omitted.
+func headerAttrs(key string, h http.Header) []pluginAttr {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		if !privateHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var attrs []pluginAttr
+	for _, name := range names {
+		for _, val := range h[name] {
+			attrs = append(attrs, pluginAttr{key, name + ": " + val})
+		}
+	}
+	return attrs
+}
+
+// pluginStderr returns the writer that the standard error
+// of a plugin run for the given request is written to.
+func pluginStderr(req *http.Request) io.Writer {
+	if u := userRequestOf(req); u != nil {
+		return u.stderr
+	}
+	return os.Stderr
+}
+
+// run runs the plugin with the given argument and input
+// attributes and returns the attributes that it outputs.
+// The plugin's standard error is written to stderr so that
+// it can interact with the user.
+func (a *pluginAuth) run(ctx context.Context, arg string, attrs []pluginAttr, stderr io.Writer) ([]pluginAttr, error) {
+	var stdin bytes.Buffer
+	for _, attr := range attrs {
+		if strings.ContainsAny(attr.val, "\r\n") {
+			return nil, fmt.Errorf("cannot pass %s attribute containing newline to auth plugin %s", attr.key, a.name())
+		}
+		fmt.Fprintf(&stdin, "%s=%s\n", attr.key, attr.val)
+	}
+	stdin.WriteString("\n")
+	cmd := exec.CommandContext(ctx, a.path, arg)
+	cmd.Stdin = &stdin
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("auth plugin %s %s failed: %v", a.name(), arg, err)
+	}
+	result, err := parsePluginAttrs(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("invalid output from auth plugin %s %s: %v", a.name(), arg, err)
+	}
+	return result, nil
+}
+
+// parsePluginAttrs parses key=value attribute lines
+// up to the first blank line or the end of the input.
+func parsePluginAttrs(r io.Reader) ([]pluginAttr, error) {
+	var attrs []pluginAttr
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %q is not of the form key=value", line)
+		}
+		attrs = append(attrs, pluginAttr{line[:i], line[i+1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
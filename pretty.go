@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// palette holds the ANSI escape sequences used to
+// highlight different parts of the output.
+// An empty sequence means that the corresponding
+// item is printed without highlighting.
+type palette struct {
+	method      string
+	proto       string
+	statusOK    string
+	statusRedir string
+	statusError string
+	headerName  string
+	headerValue string
+	key         string
+	str         string
+	number      string
+	literal     string
+	punct       string
+}
+
+// noColors is the palette used when colors are disabled.
+var noColors = &palette{}
+
+// palettes holds the available color styles, as
+// selected by the --style flag.
+var palettes = map[string]*palette{
+	"default": {
+		method:      "32;1",
+		proto:       "34",
+		statusOK:    "32",
+		statusRedir: "33",
+		statusError: "31",
+		headerName:  "36",
+		headerValue: "",
+		key:         "34;1",
+		str:         "33",
+		number:      "36",
+		literal:     "35",
+		punct:       "",
+	},
+	"bright": {
+		method:      "92;1",
+		proto:       "94",
+		statusOK:    "92;1",
+		statusRedir: "93;1",
+		statusError: "91;1",
+		headerName:  "96",
+		headerValue: "97",
+		key:         "94;1",
+		str:         "93",
+		number:      "96",
+		literal:     "95",
+		punct:       "97",
+	},
+	"solarized": {
+		method:      "38;5;64",
+		proto:       "38;5;33",
+		statusOK:    "38;5;64",
+		statusRedir: "38;5;136",
+		statusError: "38;5;160",
+		headerName:  "38;5;37",
+		headerValue: "38;5;245",
+		key:         "38;5;33",
+		str:         "38;5;37",
+		number:      "38;5;125",
+		literal:     "38;5;166",
+		punct:       "38;5;245",
+	},
+	"mono": {
+		method:      "1",
+		proto:       "1",
+		statusOK:    "1",
+		statusRedir: "1",
+		statusError: "1;4",
+		headerName:  "1",
+		key:         "1",
+	},
+}
+
+// styleNames returns the names of all the available
+// color styles in alphabetical order.
+func styleNames() []string {
+	names := make([]string, 0, len(palettes))
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paint returns s highlighted with the given
+// escape sequence.
+func paint(code, s string) string {
+	if code == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// status returns the sequence to use to highlight
+// a response with the given status code.
+func (pal *palette) status(code int) string {
+	switch code / 100 {
+	case 1, 2:
+		return pal.statusOK
+	case 3:
+		return pal.statusRedir
+	}
+	return pal.statusError
+}
+
+// palette returns the palette to use for output
+// as specified by the parameters.
+func (p *params) palette() *palette {
+	if !p.colors {
+		return noColors
+	}
+	return palettes[p.style]
+}
+
+// isTerminal reports whether f refers to a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// writeJSON writes the given JSON or rjson text to w, highlighting
+// its tokens with the given palette.
+func writeJSON(w io.Writer, data []byte, pal *palette) error {
+	if *pal == *noColors {
+		_, err := w.Write(data)
+		return err
+	}
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		n := jsonTokenLen(data)
+		tok := string(data[:n])
+		data = data[n:]
+		switch c := tok[0]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			buf.WriteString(tok)
+		case strings.IndexByte("{}[],:", c) >= 0:
+			buf.WriteString(paint(pal.punct, tok))
+		case isKey(data):
+			buf.WriteString(paint(pal.key, tok))
+		case c == '"':
+			buf.WriteString(paint(pal.str, tok))
+		case tok == "true" || tok == "false" || tok == "null":
+			buf.WriteString(paint(pal.literal, tok))
+		case c == '-' || '0' <= c && c <= '9':
+			buf.WriteString(paint(pal.number, tok))
+		default:
+			buf.WriteString(tok)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// isKey reports whether the token before the
+// given remaining data is an object key, which
+// is the case when it's followed by a colon.
+func isKey(rest []byte) bool {
+	rest = bytes.TrimLeft(rest, " \t\r\n")
+	return len(rest) > 0 && rest[0] == ':'
+}
+
+// jsonTokenLen returns the length of the token
+// at the start of data, which must be non-empty.
+// Runs of white space count as a single token.
+func jsonTokenLen(data []byte) int {
+	switch c := data[0]; {
+	case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		n := 1
+		for n < len(data) && strings.IndexByte(" \t\r\n", data[n]) >= 0 {
+			n++
+		}
+		return n
+	case strings.IndexByte("{}[],:", c) >= 0:
+		return 1
+	case c == '"':
+		for n := 1; n < len(data); n++ {
+			switch data[n] {
+			case '\\':
+				n++
+			case '"':
+				return n + 1
+			}
+		}
+		return len(data)
+	}
+	n := 1
+	for n < len(data) && strings.IndexByte(" \t\r\n{}[],:\"", data[n]) < 0 {
+		n++
+	}
+	return n
+}
+
+// printStatusLine prints the status line of an HTTP response.
+func printStatusLine(w io.Writer, resp *http.Response, pal *palette) {
+	fmt.Fprintf(w, "%s %s\n", paint(pal.proto, resp.Proto), paint(pal.status(resp.StatusCode), resp.Status))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyValue implements flag.Value for the --proxy flag.
+// Each value takes the form scheme:URL and sets the proxy
+// to use for requests with the given URL scheme.
+type proxyValue struct {
+	proxies *map[string]*url.URL
+}
+
+func (v proxyValue) String() string {
+	if v.proxies == nil {
+		return ""
+	}
+	var ss []string
+	for scheme, u := range *v.proxies {
+		// Omit the path and any user information.
+		ss = append(ss, scheme+":"+u.Scheme+"://"+u.Host)
+	}
+	return strings.Join(ss, ",")
+}
+
+func (v proxyValue) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return fmt.Errorf("proxy %q not in scheme:URL form", s)
+	}
+	scheme, proxyStr := strings.ToLower(s[:i]), s[i+1:]
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("cannot use proxy for scheme %q (must be http or https)", scheme)
+	}
+	u, err := url.Parse(proxyStr)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q", proxyStr)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy type %q in %q (must be http, https, socks5 or socks5h)", u.Scheme, proxyStr)
+	}
+	if *v.proxies == nil {
+		*v.proxies = make(map[string]*url.URL)
+	}
+	(*v.proxies)[scheme] = u
+	return nil
+}
+
+// proxyFunc returns the function used by the HTTP transport
+// to choose a proxy for a request. Proxies specified with
+// --proxy take precedence over those in the HTTP_PROXY and
+// HTTPS_PROXY environment variables, but are still
+// not used for hosts matched by NO_PROXY.
+//
+// User name and password in a proxy URL are used to
+// authenticate to the proxy.
+func proxyFunc(proxies map[string]*url.URL) func(*http.Request) (*url.URL, error) {
+	if len(proxies) == 0 {
+		return http.ProxyFromEnvironment
+	}
+	noProxy := getEnvAny("NO_PROXY", "no_proxy")
+	return func(req *http.Request) (*url.URL, error) {
+		u, ok := proxies[req.URL.Scheme]
+		if !ok {
+			return http.ProxyFromEnvironment(req)
+		}
+		if matchNoProxy(noProxy, req.URL.Host) {
+			return nil, nil
+		}
+		return u, nil
+	}
+}
+
+// matchNoProxy reports whether the given host (with optional port)
+// is matched by the given NO_PROXY value, which holds
+// a comma-separated list of host names, domain suffixes,
+// IP addresses or CIDR ranges, each optionally with a port.
+// The value "*" matches all hosts.
+func matchNoProxy(noProxy, hostPort string) bool {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host, port = hostPort, ""
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && ip.Equal(entryIP) {
+				return true
+			}
+			continue
+		}
+		entryHost = strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// getEnvAny returns the value of the first of the
+// given environment variables that is set.
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultMaxRedirects holds the default value
+// of the --max-redirects flag.
+const defaultMaxRedirects = 30
+
+// redirectChecker returns a function suitable for use as the
+// CheckRedirect field of the given client that implements the
+// redirect policy specified by p. Unless --follow is specified,
+// redirects are not followed and the redirect response
+// itself is returned.
+//
+// The policy applies only to the request made on behalf of the
+// user (see userRequest), using its own parameters if it has them.
+// Requests that the bakery client makes itself, such as for
+// macaroon discharges and logins, get the default policy
+// of the net/http package.
+//
+// When --all is specified, the body of each intermediate
+// response is kept in memory so that it can be printed
+// along with the final response (see redirectHistory).
+func redirectChecker(p0 *params, client *http.Client) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		u := userRequestOf(req)
+		if u == nil {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return nil
+		}
+		p := p0
+		if u.p != nil {
+			p = u.p
+		}
+		if !p.follow {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > p.maxRedirects {
+			return fmt.Errorf("stopped after %d redirects (see --max-redirects)", p.maxRedirects)
+		}
+		if p.all && req.Response != nil {
+			// The client closes the body of the redirect
+			// response when we return, so keep a copy.
+			resp := *req.Response
+			data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMemoryBody))
+			if err != nil {
+				return fmt.Errorf("cannot read redirect response body: %v", err)
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+			req.Response = &resp
+		}
+		if p.debug {
+			headers, cookies := droppedHeaders(via[len(via)-1], req, client.Jar)
+			if len(headers) > 0 || len(cookies) > 0 {
+				fmt.Fprintf(os.Stderr, "redirect to %s dropped headers [%s] cookies [%s]\n", req.URL, strings.Join(headers, " "), strings.Join(cookies, " "))
+			}
+		}
+		return nil
+	}
+}
+
+// droppedHeaders returns the names of the headers and cookies
+// sent with the prev request that will not be sent with the
+// redirected next request. The net/http package removes
+// headers such as Authorization when redirecting to a different
+// host, and cookies from the jar (including macaroons)
+// are only sent to the hosts that they're valid for.
+func droppedHeaders(prev, next *http.Request, jar http.CookieJar) (headers, cookies []string) {
+	for name := range prev.Header {
+		if name != "Cookie" && name != "Referer" && next.Header[name] == nil {
+			headers = append(headers, name)
+		}
+	}
+	sent := make(map[string]bool)
+	for _, c := range next.Cookies() {
+		sent[c.Name] = true
+	}
+	if jar != nil {
+		for _, c := range jar.Cookies(next.URL) {
+			sent[c.Name] = true
+		}
+	}
+	for _, c := range prev.Cookies() {
+		if !sent[c.Name] {
+			cookies = append(cookies, c.Name)
+		}
+	}
+	sort.Strings(headers)
+	sort.Strings(cookies)
+	return headers, cookies
+}
+
+// redirectHistory returns the intermediate responses that
+// were received before resp, oldest first, when redirects
+// have been followed.
+func redirectHistory(resp *http.Response) []*http.Response {
+	var history []*http.Response
+	for r := resp; r.Request != nil && r.Request.Response != nil; r = r.Request.Response {
+		history = append(history, r.Request.Response)
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// session holds the state stored in a session
+// as specified by the --session flag.
+type session struct {
+	// dir holds the directory that the session is stored in.
+	// The cookies are stored in a separate file in the same
+	// directory so that they can be managed by the cookie jar.
+	dir string
+
+	// changes holds the updates made to the session by the
+	// current request. They are merged into the stored session
+	// when it's saved, so that concurrent requests in a batch
+	// don't lose one another's updates.
+	changes *session
+
+	Headers   http.Header `json:"headers,omitempty"`
+	Auth      string      `json:"auth,omitempty"`
+	AuthType  string      `json:"authType,omitempty"`
+	AgentFile string      `json:"agentFile,omitempty"`
+}
+
+const (
+	sessionFile       = "session.json"
+	sessionCookieFile = "cookies"
+)
+
+// sessionDir returns the directory holding the session with the
+// given name for requests to the given URL. A name containing a
+// path separator is taken to be the path of the directory itself;
+// otherwise the session is stored under ~/.bhttp/sessions, scoped
+// to the URL's host.
+func sessionDir(name string, u *url.URL) string {
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return name
+	}
+	host := strings.Replace(u.Host, ":", "_", -1)
+	return filepath.Join(homeDir(), ".bhttp", "sessions", host, name)
+}
+
+// loadSession loads the session stored in the given directory.
+// If the session does not exist, it returns an empty session.
+func loadSession(dir string) (*session, error) {
+	s := &session{
+		dir: dir,
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, sessionFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("cannot parse session %s: %v", dir, err)
+	}
+	return s, nil
+}
+
+// update updates the session with the headers and credentials
+// specified for the current request. Headers that are specific
+// to a single request, such as Content-Type, are not stored.
+func (s *session) update(p *params, h http.Header) {
+	c := &session{
+		Auth:      p.auth,
+		AuthType:  p.authType,
+		AgentFile: p.agentFile,
+	}
+	for name, vals := range h {
+		if strings.HasPrefix(name, "Content-") || strings.HasPrefix(name, "If-") {
+			continue
+		}
+		if c.Headers == nil {
+			c.Headers = make(http.Header)
+		}
+		c.Headers[name] = vals
+	}
+	s.merge(c)
+	s.changes = c
+}
+
+// merge merges the headers and credentials set in c into s.
+func (s *session) merge(c *session) {
+	for name, vals := range c.Headers {
+		if s.Headers == nil {
+			s.Headers = make(http.Header)
+		}
+		s.Headers[name] = vals
+	}
+	if c.Auth != "" {
+		s.Auth, s.AuthType = c.Auth, c.AuthType
+	}
+	if c.AgentFile != "" {
+		s.AgentFile = c.AgentFile
+	}
+}
+
+// apply applies the session to the current request. Headers
+// and credentials specified for the request take precedence
+// over those in the session, and cookies are stored in the
+// session rather than in the --cookiefile jar.
+func (s *session) apply(p *params, h http.Header) {
+	for name, vals := range s.Headers {
+		if h[name] == nil {
+			h[name] = vals
+		}
+	}
+	if p.auth == "" && s.Auth != "" {
+		p.auth, p.authType = s.Auth, s.AuthType
+	}
+	if p.agentFile == "" {
+		p.agentFile = s.AgentFile
+	}
+	if p.cookieFile != "" {
+		p.cookieFile = filepath.Join(s.dir, sessionCookieFile)
+	}
+}
+
+// saveMu guards the read-modify-write cycles of files
+// that may be updated by several requests in a batch.
+var saveMu sync.Mutex
+
+// save merges the updates made to the session into the
+// stored session and saves it, creating its directory if
+// needed. The session may hold credentials, so it is only
+// readable by the current user.
+func (s *session) save() error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	stored, err := loadSession(s.dir)
+	if err != nil {
+		return err
+	}
+	if s.changes != nil {
+		stored.merge(s.changes)
+	}
+	data, err := json.MarshalIndent(stored, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(filepath.Join(s.dir, sessionFile), append(data, '\n'))
+}
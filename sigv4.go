@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials holds the credentials used to sign
+// requests with AWS Signature Version 4.
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// sigv4Signer implements authProvider by signing
+// requests with AWS Signature Version 4.
+type sigv4Signer struct {
+	creds   awsCredentials
+	region  string
+	service string
+}
+
+const (
+	sigv4Algorithm  = "AWS4-HMAC-SHA256"
+	sigv4TimeFormat = "20060102T150405Z"
+)
+
+// newSigv4Signer returns a signer using the region and service
+// specified by p. The credentials are taken from --auth
+// (ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN]) if specified,
+// or from the environment or the shared credentials file otherwise.
+func newSigv4Signer(p *params) (authProvider, error) {
+	region := p.awsRegion
+	if region == "" {
+		region = getEnvAny("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, fmt.Errorf("no AWS region specified (use --aws-region or $AWS_REGION)")
+	}
+	if p.awsService == "" {
+		return nil, fmt.Errorf("no AWS service specified (use --aws-service)")
+	}
+	var creds awsCredentials
+	if p.auth != "" {
+		parts := strings.SplitN(p.auth, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("--auth must be in the form ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN] with --auth-type=aws-sigv4")
+		}
+		creds.accessKeyID, creds.secretAccessKey = parts[0], parts[1]
+		if len(parts) == 3 {
+			creds.sessionToken = parts[2]
+		}
+	} else {
+		var err error
+		creds, err = awsCredentialsFromEnvironment()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &sigv4Signer{
+		creds:   creds,
+		region:  region,
+		service: p.awsService,
+	}, nil
+}
+
+// awsCredentialsFromEnvironment returns the AWS credentials held in
+// the standard environment variables or, if they're not set, in the
+// profile named by $AWS_PROFILE (default "default") in the shared
+// credentials file.
+func awsCredentialsFromEnvironment() (awsCredentials, error) {
+	creds := awsCredentials{
+		accessKeyID:     getEnvAny("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY"),
+		secretAccessKey: getEnvAny("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID != "" && creds.secretAccessKey != "" {
+		return creds, nil
+	}
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = filepath.Join(homeDir(), ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return awsCredentials{}, fmt.Errorf("no AWS credentials found in environment or %s", path)
+		}
+		return awsCredentials{}, fmt.Errorf("cannot read AWS credentials: %v", err)
+	}
+	vals := parseINISection(string(data), profile)
+	creds = awsCredentials{
+		accessKeyID:     vals["aws_access_key_id"],
+		secretAccessKey: vals["aws_secret_access_key"],
+		sessionToken:    vals["aws_session_token"],
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found for profile %q in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// parseINISection returns the key-value pairs in the
+// named section of the given INI-format data.
+func parseINISection(data, section string) map[string]string {
+	vals := make(map[string]string)
+	inSection := false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+		case inSection:
+			if i := strings.Index(line, "="); i > 0 {
+				vals[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return vals
+}
+
+// prepare implements authProvider.prepare by signing the request.
+func (s *sigv4Signer) prepare(ctx context.Context, client *http.Client, req *http.Request) error {
+	return s.sign(req, time.Now())
+}
+
+func (s *sigv4Signer) challenge(ctx context.Context, client *http.Client, req *http.Request, resp *http.Response) (bool, error) {
+	return false, nil
+}
+
+func (s *sigv4Signer) save(resp *http.Response) error {
+	return nil
+}
+
+// sign signs the given request as if it was sent at the given time,
+// adding the X-Amz-Date, X-Amz-Security-Token, Authorization and,
+// for S3, X-Amz-Content-Sha256 headers as appropriate. The request body,
+// if any, must be seekable; it's rewound after being hashed.
+func (s *sigv4Signer) sign(req *http.Request, now time.Time) error {
+	payloadHash := sha256.New()
+	if req.Body != nil {
+		body, ok := req.Body.(io.ReadSeeker)
+		if !ok {
+			return fmt.Errorf("cannot sign request: body is not seekable")
+		}
+		if _, err := io.Copy(payloadHash, body); err != nil {
+			return fmt.Errorf("cannot read request body: %v", err)
+		}
+		if err := rewind(body); err != nil {
+			return err
+		}
+	}
+	payloadHex := hex.EncodeToString(payloadHash.Sum(nil))
+	amzDate := now.UTC().Format(sigv4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.service == "s3" {
+		// S3 requires the payload hash to be sent too.
+		req.Header.Set("X-Amz-Content-Sha256", payloadHex)
+	}
+	if s.creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.creds.sessionToken)
+	}
+	canonicalHeaders, signedHeaders := sigv4CanonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigv4CanonicalURI(req.URL.Path, s.service),
+		sigv4CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHex,
+	}, "\n")
+	scope := strings.Join([]string{amzDate[:8], s.region, s.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigv4Algorithm,
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+	key := []byte("AWS4" + s.creds.secretAccessKey)
+	for _, elem := range []string{amzDate[:8], s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, elem)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigv4Algorithm,
+		s.creds.accessKeyID,
+		scope,
+		signedHeaders,
+		signature,
+	))
+	return nil
+}
+
+// sigv4CanonicalHeaders returns the canonical headers and the
+// signed headers list for the given request. The Host,
+// Content-Type and X-Amz-* headers are signed. Other headers,
+// such as Cookie, may be changed by the HTTP client after
+// signing so they're not included.
+func sigv4CanonicalHeaders(req *http.Request) (canonical, signed string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{
+		"host": host,
+	}
+	for name, vals := range req.Header {
+		name = strings.ToLower(name)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+		trimmed := make([]string, len(vals))
+		for i, v := range vals {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return buf.String(), strings.Join(names, ";")
+}
+
+// sigv4CanonicalURI returns the canonical form of the given
+// URL path. Each path segment is URI-encoded twice, except
+// for Amazon S3, where it's encoded once.
+func sigv4CanonicalURI(path, service string) string {
+	if path == "" {
+		return "/"
+	}
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		seg = awsURIEncode(seg)
+		if service != "s3" {
+			seg = awsURIEncode(seg)
+		}
+		segs[i] = seg
+	}
+	return strings.Join(segs, "/")
+}
+
+// sigv4CanonicalQuery returns the canonical form of the
+// given query parameters, sorted by name and then value.
+func sigv4CanonicalQuery(q url.Values) string {
+	var params []string
+	for name, vals := range q {
+		for _, v := range vals {
+			params = append(params, awsURIEncode(name)+"="+awsURIEncode(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsURIEncode percent-encodes all bytes of s other
+// than the RFC 3986 unreserved characters.
+func awsURIEncode(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
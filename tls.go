@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"golang.org/x/crypto/ocsp"
+)
+
+// verifyValue implements flag.Value for the --verify flag.
+// The value "no" disables certificate verification;
+// "yes" uses the system certificate pool only. Any other
+// value is taken as the name of a file or directory holding
+// PEM-encoded CA certificates to trust in addition to the
+// system pool. The flag may be given more than once.
+type verifyValue struct {
+	p *params
+}
+
+func (v verifyValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(v.p.caFiles, ",")
+}
+
+func (v verifyValue) Set(s string) error {
+	switch strings.ToLower(s) {
+	case "no", "false":
+		v.p.insecure = true
+	case "yes", "true":
+		v.p.insecure = false
+	default:
+		v.p.caFiles = append(v.p.caFiles, s)
+	}
+	return nil
+}
+
+// newTLSConfig returns the TLS configuration to use for
+// all connections, including those made to discharge macaroons,
+// as specified by p. It returns nil if the default configuration
+// should be used.
+func newTLSConfig(p *params) (*tls.Config, error) {
+	if !p.insecure && len(p.caFiles) == 0 && p.certFile == "" && p.tlsVersion == 0 && p.ciphers == nil {
+		return nil, nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: p.insecure,
+		MinVersion:         p.tlsVersion,
+		MaxVersion:         p.tlsVersion,
+		CipherSuites:       p.ciphers,
+	}
+	if len(p.caFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, path := range p.caFiles {
+			if err := addCACerts(pool, path); err != nil {
+				return nil, err
+			}
+		}
+		config.RootCAs = pool
+	}
+	if p.certFile != "" {
+		cert, err := loadClientCert(p.certFile, p.certKeyFile, p.certKeyPass)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if p.certKeyFile != "" {
+		return nil, fmt.Errorf("--cert-key specified without --cert")
+	}
+	return config, nil
+}
+
+// addCACerts adds the PEM-encoded certificates found in the
+// file at path, or in all the files in it if it's a directory,
+// to pool.
+func addCACerts(pool *x509.CertPool, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot read CA certificates: %v", err)
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("cannot read CA certificates: %v", err)
+		}
+		paths = paths[:0]
+		for _, info := range infos {
+			if info.Mode().IsRegular() {
+				paths = append(paths, filepath.Join(path, info.Name()))
+			}
+		}
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read CA certificates: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(data) && !info.IsDir() {
+			return fmt.Errorf("no PEM-encoded certificates found in %s", path)
+		}
+	}
+	return nil
+}
+
+// loadClientCert loads a client certificate from certFile. The private
+// key is read from keyFile, or from certFile if keyFile is empty.
+// If the key is encrypted, it is decrypted with the given password.
+func loadClientCert(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot read client certificate: %v", err)
+	}
+	keyPEM := certPEM
+	if keyFile != "" {
+		keyPEM, err = ioutil.ReadFile(keyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("cannot read client certificate key: %v", err)
+		}
+	}
+	keyPEM, err = decryptKeyPEM(keyPEM, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot read client certificate key: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot load client certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// decryptKeyPEM returns the private key block found in the given
+// PEM data, decrypted with the given password if necessary.
+func decryptKeyPEM(data []byte, password string) ([]byte, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key found")
+		}
+		encrypted := block.Type == "ENCRYPTED PRIVATE KEY"
+		switch {
+		case !strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			continue
+		case !encrypted && !x509.IsEncryptedPEMBlock(block):
+			return pem.EncodeToMemory(block), nil
+		}
+		if password == "" {
+			return nil, fmt.Errorf("key is encrypted but no password was given (use --cert-key-pass or $BHTTP_CERT_KEY_PASS)")
+		}
+		if encrypted {
+			// Only the PBES2 scheme, with PBKDF2 and AES or
+			// triple-DES in CBC mode, is supported. This is what
+			// current versions of OpenSSL produce.
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+			if err != nil {
+				return nil, err
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(&pem.Block{
+				Type:  "PRIVATE KEY",
+				Bytes: der,
+			}), nil
+		}
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  block.Type,
+			Bytes: der,
+		}), nil
+	}
+}
+
+// tlsVersions maps the values accepted by the --ssl flag
+// to TLS protocol versions.
+var tlsVersions = map[string]uint16{
+	"tls1":   tls.VersionTLS10,
+	"tls1.1": tls.VersionTLS11,
+	"tls1.2": tls.VersionTLS12,
+	"tls1.3": tls.VersionTLS13,
+}
+
+// tlsVersionName returns the name of the given TLS version.
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("unknown (%#04x)", v)
+}
+
+// parseCiphers parses a comma-separated list of cipher suite
+// names as accepted by the --ciphers flag.
+func parseCiphers(s string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// printTLSInfo prints information about the TLS connection
+// described by state.
+func printTLSInfo(w io.Writer, state *tls.ConnectionState, pal *palette) {
+	field := func(name, f string, a ...interface{}) {
+		fmt.Fprintf(w, "%s: %s\n", paint(pal.headerName, name), paint(pal.headerValue, fmt.Sprintf(f, a...)))
+	}
+	field("TLS-Version", "%s", tlsVersionName(state.Version))
+	field("TLS-Cipher-Suite", "%s", tls.CipherSuiteName(state.CipherSuite))
+	if state.NegotiatedProtocol != "" {
+		field("TLS-ALPN-Protocol", "%s", state.NegotiatedProtocol)
+	} else {
+		field("TLS-ALPN-Protocol", "none")
+	}
+	field("TLS-OCSP-Staple", "%s", ocspInfo(state))
+	for i, cert := range state.PeerCertificates {
+		field(fmt.Sprintf("TLS-Certificate-%d", i), "%s; issuer %s; expires %s",
+			cert.Subject,
+			cert.Issuer,
+			cert.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// ocspInfo returns a description of the OCSP response
+// stapled to the TLS connection described by state.
+func ocspInfo(state *tls.ConnectionState) string {
+	if len(state.OCSPResponse) == 0 {
+		return "none"
+	}
+	var issuer *x509.Certificate
+	if len(state.PeerCertificates) > 1 {
+		issuer = state.PeerCertificates[1]
+	}
+	resp, err := ocsp.ParseResponse(state.OCSPResponse, issuer)
+	if err != nil {
+		return fmt.Sprintf("invalid (%v)", err)
+	}
+	status := "unknown"
+	switch resp.Status {
+	case ocsp.Good:
+		status = "good"
+	case ocsp.Revoked:
+		status = "revoked at " + resp.RevokedAt.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%s; produced %s; next update %s",
+		status,
+		resp.ProducedAt.UTC().Format(time.RFC3339),
+		resp.NextUpdate.UTC().Format(time.RFC3339),
+	)
+}